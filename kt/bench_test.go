@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func BenchmarkSet(b *testing.B) {
@@ -18,7 +19,7 @@ func BenchmarkSet(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		str := strconv.Itoa(i)
-		conn.set(ctx, str, []byte(str))
+		conn.Set(ctx, str, []byte(str), time.Time{})
 	}
 }
 
@@ -34,7 +35,7 @@ func BenchmarkSetLarge(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		str := strconv.Itoa(i)
-		conn.set(ctx, str, large[:])
+		conn.Set(ctx, str, large[:], time.Time{})
 	}
 }
 
@@ -46,7 +47,7 @@ func BenchmarkGet(b *testing.B) {
 	if err != nil {
 		b.Fatal(err.Error())
 	}
-	err = conn.set(ctx, "something", []byte("foobar"))
+	err = conn.Set(ctx, "something", []byte("foobar"), time.Time{})
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -67,7 +68,7 @@ func BenchmarkGetLarge(b *testing.B) {
 	if err != nil {
 		b.Fatal(err.Error())
 	}
-	err = conn.set(ctx, "something", make([]byte, 4096))
+	err = conn.Set(ctx, "something", make([]byte, 4096), time.Time{})
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -95,7 +96,7 @@ func BenchmarkBulkBytes(b *testing.B) {
 	}
 
 	for k := range keys {
-		db.set(ctx, k, []byte("something"))
+		db.Set(ctx, k, []byte("something"), time.Time{})
 	}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
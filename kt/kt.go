@@ -41,16 +41,106 @@ func init() {
 
 const DEFAULT_TIMEOUT = 2 * time.Second
 
+// Class is the kind of an Error, after the status codes of KT.
+type Class int
+
+const (
+	// Not coming from KT, or of no known kind.
+	ClassUnknown Class = iota
+	// KT refused the parameters, status 400.
+	ClassInvalidArgument
+	// The operation conflicts with the state of the database, like a
+	// missing record, status 404 and 450.
+	ClassLogicalInconsistency
+	// KT failed or doesn't implement the operation, status 500 and
+	// 501.
+	ClassImplementation
+	// The operation timed out, on either side, status 503.
+	ClassTimeout
+	// KT couldn't be reached, or the connection failed.
+	ClassNetwork
+)
+
+var classNames = [...]string{
+	ClassUnknown:              "unknown",
+	ClassInvalidArgument:      "invalid argument",
+	ClassLogicalInconsistency: "logical inconsistency",
+	ClassImplementation:       "implementation error",
+	ClassTimeout:              "timeout",
+	ClassNetwork:              "network error",
+}
+
+func (c Class) String() string {
+	if c < 0 || int(c) >= len(classNames) {
+		return "Class(" + strconv.Itoa(int(c)) + ")"
+	}
+	return classNames[c]
+}
+
+// The class of the errors answered with the HTTP status code.
+func classOf(code int) Class {
+	switch code {
+	case 400:
+		return ClassInvalidArgument
+	case 404, 450:
+		return ClassLogicalInconsistency
+	case 500, 501:
+		return ClassImplementation
+	case 503:
+		return ClassTimeout
+	}
+	return ClassUnknown
+}
+
 // Error is returned by all functions in this package.
+//
+// The sentinel errors, like ErrNotFound, are returned as they are, so
+// they can still be compared with ==. The other errors tell the
+// operation and the key, and wrap the error of the connection, if
+// that's what failed. Use errors.As to inspect them.
 type Error struct {
 	// Error returned by KT
 	Message string
 	// HTTP status code, if any (0 otherwise)
 	Code int
+	// Kind of error
+	Class Class
+	// KT procedure, like get or set_bulk, if any
+	Op string
+	// Key of the record, if the procedure is about a single one
+	Key string
+	// Cause of the error, if any
+	Err error
 }
 
 func (e *Error) Error() string {
-	return fmt.Sprintln("kt:", e.Message)
+	var b strings.Builder
+	b.WriteString("kt: ")
+	if e.Op != "" {
+		b.WriteString(e.Op)
+		if e.Key != "" {
+			b.WriteString(" ")
+			b.WriteString(strconv.Quote(e.Key))
+		}
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Message)
+	if e.Err != nil {
+		if e.Message != "" {
+			b.WriteString(": ")
+		}
+		b.WriteString(e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches all the timeouts with ErrTimeout.
+func (e *Error) Is(target error) bool {
+	return target == ErrTimeout && e.Class == ClassTimeout
 }
 
 // IsError returns true if the error was generated by this package.
@@ -69,6 +159,11 @@ type Conn struct {
 	timeout    time.Duration
 	host       string
 	transport  *http.Transport
+	// The pool for the binary protocol, if enabled.
+	binary *binaryPool
+	// Requests are not sent again on a new connection, the
+	// session matters, see Cursor.
+	noRetry bool
 }
 
 func expiryCertMetric(certFile string) error {
@@ -328,11 +423,11 @@ func newUnixConn(socket string, poolsize int, timeout time.Duration) (*Conn, err
 }
 
 var (
-	ErrTimeout error = &Error{Message: "operation timeout"}
+	ErrTimeout error = &Error{Message: "operation timeout", Class: ClassTimeout}
 	// the wording on this error is deliberately weird,
 	// because users would search for the string logical inconsistency
 	// in order to find lookup misses.
-	ErrNotFound = &Error{Message: "entry not found aka logical inconsistency"}
+	ErrNotFound = &Error{Message: "entry not found aka logical inconsistency", Class: ClassLogicalInconsistency}
 	// old gokabinet returned this error on success. Keeping around "for compatibility" until
 	// I can kill it with fire.
 	ErrSuccess = &Error{Message: "success"}
@@ -359,18 +454,21 @@ func (c *Conn) Count(ctx context.Context) (int, error) {
 	}
 
 	if code != 200 {
-		err := makeError(m)
+		err := makeError("status", "", code, m)
 		span.SetTag("status", err)
 		return 0, err
 	}
 	return strconv.Atoi(string(findRec(m, "count").Value))
 }
 
-func (c *Conn) remove(ctx context.Context, key string) error {
+// Remove deletes the data at key. ErrNotFound is returned if no such
+// data exists.
+func (c *Conn) Remove(ctx context.Context, key string) error {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc Remove")
 	defer span.Finish()
+	span.SetTag("key", key)
 
-	code, body, err := c.doREST(ctx, "DELETE", key, nil)
+	code, body, err := c.doREST(ctx, "DELETE", key, emptyHeader, nil)
 	if err != nil {
 		span.SetTag("status", err)
 		return err
@@ -380,7 +478,7 @@ func (c *Conn) remove(ctx context.Context, key string) error {
 		return ErrNotFound
 	}
 	if code != 204 {
-		err := &Error{string(body), code}
+		err := restError("remove", key, code, body)
 		span.SetTag("status", err)
 		return err
 	}
@@ -430,7 +528,7 @@ func (c *Conn) Get(ctx context.Context, key string) (string, error) {
 func (c *Conn) doGet(ctx context.Context, key string) ([]byte, error) {
 	span := opentracing.SpanFromContext(ctx)
 
-	code, body, err := c.doREST(ctx, "GET", key, nil)
+	code, body, err := c.doREST(ctx, "GET", key, emptyHeader, nil)
 	if err != nil {
 		span.SetTag("err", err)
 		return nil, err
@@ -444,7 +542,7 @@ func (c *Conn) doGet(ctx context.Context, key string) ([]byte, error) {
 		span.SetTag("status", "not_found")
 		return nil, ErrNotFound
 	default:
-		err := &Error{string(body), code}
+		err := restError("get", key, code, body)
 		span.SetTag("status", err)
 		return nil, err
 	}
@@ -460,22 +558,38 @@ func (c *Conn) GetBytes(ctx context.Context, key string) ([]byte, error) {
 	return c.doGet(ctx, key)
 }
 
-// Set stores the data at key
-func (c *Conn) set(ctx context.Context, key string, value []byte) error {
+// Set stores the data at key. The record expires at expire, unless
+// it's zero.
+func (c *Conn) Set(ctx context.Context, key string, value []byte, expire time.Time) error {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc Set")
 	defer span.Finish()
+	span.SetTag("key", key)
 
-	code, body, err := c.doREST(ctx, "PUT", key, value)
+	headers := emptyHeader
+	if !expire.IsZero() {
+		headers = http.Header{"X-Kt-Xt": {expire.UTC().Format(http.TimeFormat)}}
+	}
+	code, body, err := c.doREST(ctx, "PUT", key, headers, value)
 	if err != nil {
+		span.SetTag("status", err)
 		return err
 	}
 	if code != 201 {
-		return &Error{string(body), code}
+		err := restError("set", key, code, body)
+		span.SetTag("status", err)
+		return err
 	}
 
 	return nil
 }
 
+// The xt parameter of the RPC calls. Negative values are absolute
+// UNIX times, positive ones would be relative to the time on the
+// server.
+func xtValue(expire time.Time) []byte {
+	return []byte(strconv.FormatInt(-expire.Unix(), 10))
+}
+
 var zeroslice = []byte("0")
 
 // GetBulkBytes retrieves the keys in the map. The results will be filled in on function return.
@@ -493,6 +607,9 @@ func (c *Conn) GetBulkBytes(ctx context.Context, keys map[string][]byte) error {
 // doGetBulkBytes retrieves the keys in the map. The results will be filled in on function return.
 // If a key was not found in the database, it will be removed from the map.
 func (c *Conn) doGetBulkBytes(ctx context.Context, keys map[string][]byte) error {
+	if c.binary != nil {
+		return c.binaryGetBulkBytes(ctx, keys)
+	}
 
 	// The format for querying multiple keys in KT is to send a
 	// TSV value for each key with a _ as a prefix.
@@ -511,7 +628,7 @@ func (c *Conn) doGetBulkBytes(ctx context.Context, keys map[string][]byte) error
 		return err
 	}
 	if code != 200 {
-		return makeError(m)
+		return makeError("get_bulk", "", code, m)
 	}
 	for _, kv := range m {
 		if kv.Key[0] != '_' {
@@ -527,14 +644,27 @@ func (c *Conn) doGetBulkBytes(ctx context.Context, keys map[string][]byte) error
 	return nil
 }
 
-// SetBulk stores the values in the map.
-func (c *Conn) setBulk(ctx context.Context, values map[string]string) (int64, error) {
-	vals := make([]KV, 0, len(values))
+// SetBulk stores the values in the map and returns the number of
+// stored records. All of them expire at expire, unless it's zero.
+func (c *Conn) SetBulk(ctx context.Context, values map[string]string, expire time.Time) (int64, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc SetBulk")
+	defer span.Finish()
+
+	if c.binary != nil {
+		n, err := c.binarySetBulk(ctx, values, expire)
+		if err != nil {
+			span.SetTag("status", err)
+		}
+		return n, err
+	}
+
+	vals := make([]KV, 0, len(values)+1)
+	if !expire.IsZero() {
+		vals = append(vals, KV{"xt", xtValue(expire)})
+	}
 	for k, v := range values {
 		vals = append(vals, KV{"_" + k, []byte(v)})
 	}
-	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc SetBulk")
-	defer span.Finish()
 
 	code, m, err := c.doRPC(ctx, "/rpc/set_bulk", vals)
 	if err != nil {
@@ -543,20 +673,30 @@ func (c *Conn) setBulk(ctx context.Context, values map[string]string) (int64, er
 	}
 	if code != 200 {
 		span.SetTag("status", code)
-		return 0, makeError(m)
+		return 0, makeError("set_bulk", "", code, m)
 	}
 	return strconv.ParseInt(string(findRec(m, "num").Value), 10, 64)
 }
 
-func (c *Conn) removeBulk(ctx context.Context, keys []string) (int64, error) {
+// RemoveBulk deletes the keys and returns the number of removed
+// records. Keys that don't exist are ignored.
+func (c *Conn) RemoveBulk(ctx context.Context, keys []string) (int64, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc RemoveBulk")
+	defer span.Finish()
+
+	if c.binary != nil {
+		n, err := c.binaryRemoveBulk(ctx, keys)
+		if err != nil {
+			span.SetTag("status", err)
+		}
+		return n, err
+	}
+
 	vals := make([]KV, 0, len(keys))
 	for _, k := range keys {
 		vals = append(vals, KV{"_" + k, zeroslice})
 	}
 
-	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc RemoveBulk")
-	defer span.Finish()
-
 	code, m, err := c.doRPC(ctx, "/rpc/remove_bulk", vals)
 	if err != nil {
 		span.SetTag("status", err)
@@ -564,7 +704,7 @@ func (c *Conn) removeBulk(ctx context.Context, keys []string) (int64, error) {
 	}
 	if code != 200 {
 		span.SetTag("status", code)
-		return 0, makeError(m)
+		return 0, makeError("remove_bulk", "", code, m)
 	}
 	return strconv.ParseInt(string(findRec(m, "num").Value), 10, 64)
 }
@@ -591,7 +731,7 @@ func (c *Conn) MatchPrefix(ctx context.Context, key string, maxrecords int64) ([
 	}
 	if code != 200 {
 		span.SetTag("status", code)
-		return nil, makeError(m)
+		return nil, makeError("match_prefix", "", code, m)
 	}
 	res := make([]string, 0, len(m))
 	for _, kv := range m {
@@ -637,9 +777,10 @@ func (c *Conn) doRPC(ctx context.Context, path string, values []KV) (code int, v
 	if enc == Base64Enc {
 		headers = base64headers
 	}
+	op, key := strings.TrimPrefix(path, "/rpc/"), string(findRec(values, "key").Value)
 	resp, t, err := c.roundTrip(ctx, "POST", url, headers, body)
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, opError(op, key, err)
 	}
 	resultBody, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
@@ -647,11 +788,11 @@ func (c *Conn) doRPC(ctx context.Context, path string, values []KV) (code int, v
 		return 0, nil, ErrTimeout
 	}
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, opError(op, key, err)
 	}
 	m, err := DecodeValues(resultBody, resp.Header.Get("Content-Type"))
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, opError(op, key, err)
 	}
 	return resp.StatusCode, m, nil
 }
@@ -659,7 +800,7 @@ func (c *Conn) doRPC(ctx context.Context, path string, values []KV) (code int, v
 func (c *Conn) roundTrip(ctx context.Context, method string, url *url.URL, headers http.Header, body []byte) (*http.Response, *time.Timer, error) {
 	req, t := c.makeRequest(ctx, method, url, headers, body)
 	resp, err := c.transport.RoundTrip(req)
-	if err != nil {
+	if err != nil && !c.noRetry {
 		// Ideally we would only retry when we hit a network error. This doesn't work
 		// since net/http wraps some of these errors. Do the simple thing and retry eagerly.
 		t.Stop()
@@ -888,14 +1029,54 @@ func unhex(c byte) byte {
 	return 0
 }
 
-// TODO: make this return errors that can be introspected more easily
-// and make it trim components of the error to filter out unused information.
-func makeError(m []KV) error {
-	kv := findRec(m, "ERROR")
-	if kv.Key == "" {
-		return &Error{Message: "generic error"}
+// The error of the RPC procedure answered with the status code. KT
+// prefixes the messages of the database errors with the code of
+// Kyoto Cabinet, like "DB: 7: no record: no record", which isn't
+// kept.
+func makeError(op, key string, code int, m []KV) error {
+	msg := "generic error"
+	if kv := findRec(m, "ERROR"); kv.Key != "" {
+		msg = string(kv.Value)
+	}
+	if strings.HasPrefix(msg, "DB: ") {
+		if i := strings.Index(msg[4:], ": "); i >= 0 {
+			msg = msg[4+i+2:]
+		}
 	}
-	return &Error{Message: string(kv.Value)}
+	return &Error{Message: msg, Code: code, Class: classOf(code), Op: op, Key: key}
+}
+
+// The error of the REST request answered with the status code.
+func restError(op, key string, code int, body []byte) error {
+	return &Error{Message: strings.TrimSpace(string(body)), Code: code, Class: classOf(code), Op: op, Key: key}
+}
+
+// Add the operation and the key to an error of the connection or of
+// the response, and tell its class. ErrTimeout is returned as is.
+func opError(op, key string, err error) error {
+	if err == ErrTimeout {
+		return err
+	}
+	if e, ok := err.(*Error); ok {
+		res := *e
+		res.Op, res.Key = op, key
+		return &res
+	}
+	class := ClassNetwork
+	var nerr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &nerr) && nerr.Timeout()) {
+		class = ClassTimeout
+	} else if errors.Is(err, context.Canceled) {
+		class = ClassUnknown
+	}
+	return &Error{Class: class, Op: op, Key: key, Err: err}
+}
+
+// The KT procedures of the REST requests.
+var restOps = map[string]string{
+	"GET":    "get",
+	"PUT":    "set",
+	"DELETE": "remove",
 }
 
 func findRec(kvs []KV, key string) KV {
@@ -910,23 +1091,25 @@ func findRec(kvs []KV, key string) KV {
 // empty header for REST calls.
 var emptyHeader = make(http.Header)
 
-func (c *Conn) doREST(ctx context.Context, op string, key string, val []byte) (code int, body []byte, err error) {
+func (c *Conn) doREST(ctx context.Context, op string, key string, headers http.Header, val []byte) (code int, body []byte, err error) {
 	newkey := urlenc(key)
 	url := &url.URL{
 		Scheme: c.scheme,
 		Host:   c.host,
 		Opaque: newkey,
 	}
-	resp, t, err := c.roundTrip(ctx, op, url, emptyHeader, val)
+	resp, t, err := c.roundTrip(ctx, op, url, headers, val)
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, opError(restOps[op], key, err)
 	}
 	resultBody, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
 	if !t.Stop() {
-		err = ErrTimeout
+		return 0, nil, ErrTimeout
+	} else if err != nil {
+		return 0, nil, opError(restOps[op], key, err)
 	}
-	return resp.StatusCode, resultBody, err
+	return resp.StatusCode, resultBody, nil
 }
 
 // encode the key for use in a RESTFUL url
@@ -2,6 +2,7 @@ package kt
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"os"
 	"os/exec"
@@ -103,7 +104,7 @@ func TestCount(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
-	db.set(ctx, "name", []byte("Steve Vai"))
+	db.Set(ctx, "name", []byte("Steve Vai"), time.Time{})
 	if n, err := db.Count(ctx); err != nil {
 		t.Error(err)
 	} else if n != 1 {
@@ -122,7 +123,7 @@ func TestGetSet(t *testing.T) {
 	}
 	keys := []string{"a", "b", "c"}
 	for _, k := range keys {
-		db.set(ctx, k, []byte(k))
+		db.Set(ctx, k, []byte(k), time.Time{})
 		got, _ := db.Get(ctx, k)
 		if got != k {
 			t.Errorf("Get failed: want %s, got %s.", k, got)
@@ -130,6 +131,47 @@ func TestGetSet(t *testing.T) {
 	}
 }
 
+func TestSetExpire(t *testing.T) {
+	ctx := context.Background()
+	cmd := startServer(t)
+	defer haltServer(cmd, t)
+
+	db, err := NewConn(KTHOST, KTPORT, 1, DEFAULT_TIMEOUT)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	past := time.Now().Add(-time.Hour)
+	if err := db.Set(ctx, "a", []byte("a"), past); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(ctx, "b", []byte("b"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get(ctx, "a"); err != ErrNotFound {
+		t.Errorf("Get of an expired record. Want ErrNotFound, got %v.", err)
+	}
+	if got, _ := db.Get(ctx, "b"); got != "b" {
+		t.Errorf("Get failed: want b, got %s.", got)
+	}
+
+	n, err := db.SetBulk(ctx, map[string]string{"c": "c", "d": "d"}, past)
+	if err != nil || n != 2 {
+		t.Fatalf("SetBulk failed: %d %v", n, err)
+	}
+	keys := map[string]string{"c": "", "d": ""}
+	if err := db.GetBulk(ctx, keys); err != nil || len(keys) != 0 {
+		t.Errorf("GetBulk of expired records. Want none, got %v.", keys)
+	}
+
+	if err := db.Remove(ctx, "b"); err != nil {
+		t.Error(err)
+	}
+	if err := db.Remove(ctx, "b"); err != ErrNotFound {
+		t.Errorf("Remove of a missing record. Want ErrNotFound, got %v.", err)
+	}
+}
+
 func TestMatchPrefix(t *testing.T) {
 	ctx := context.Background()
 	cmd := startServer(t)
@@ -146,7 +188,7 @@ func TestMatchPrefix(t *testing.T) {
 		"cache/news/4",
 	}
 	for _, k := range keys {
-		db.set(ctx, k, []byte("something"))
+		db.Set(ctx, k, []byte("something"), time.Time{})
 	}
 	var tests = []struct {
 		max      int64
@@ -215,7 +257,7 @@ func TestGetBulk(t *testing.T) {
 	}
 
 	for k, v := range baseKeys {
-		db.set(ctx, k, []byte(v))
+		db.Set(ctx, k, []byte(v), time.Time{})
 		testKeys[k] = ""
 	}
 
@@ -231,8 +273,8 @@ func TestGetBulk(t *testing.T) {
 	}
 
 	// Now remove some keys
-	db.remove(ctx, "cache/news/1")
-	db.remove(ctx, "cache/news/2")
+	db.Remove(ctx, "cache/news/1")
+	db.Remove(ctx, "cache/news/2")
 	delete(baseKeys, "cache/news/1")
 	delete(baseKeys, "cache/news/2")
 
@@ -277,7 +319,7 @@ func TestSetGetRemoveBulk(t *testing.T) {
 		removeKeys = append(removeKeys, k)
 	}
 
-	if _, err := db.setBulk(ctx, baseKeys); err != nil {
+	if _, err := db.SetBulk(ctx, baseKeys, time.Time{}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -291,13 +333,13 @@ func TestSetGetRemoveBulk(t *testing.T) {
 		}
 	}
 
-	if _, err := db.removeBulk(ctx, removeKeys); err != nil {
+	if _, err := db.RemoveBulk(ctx, removeKeys); err != nil {
 		t.Fatal(err)
 	}
 
 	count, _ := db.Count(ctx)
 	if count != 0 {
-		t.Errorf("db.removeBulk(). Want %v. Got %v", 0, count)
+		t.Errorf("db.RemoveBulk(). Want %v. Got %v", 0, count)
 	}
 }
 
@@ -321,7 +363,7 @@ func TestGetBulkBytes(t *testing.T) {
 	}
 
 	for k, v := range baseKeys {
-		db.set(ctx, k, v)
+		db.Set(ctx, k, v, time.Time{})
 		testKeys[k] = []byte("")
 	}
 
@@ -337,7 +379,7 @@ func TestGetBulkBytes(t *testing.T) {
 	}
 
 	// Now remove some keys
-	db.remove(ctx, "cache/news/4")
+	db.Remove(ctx, "cache/news/4")
 	delete(baseKeys, "cache/news/4")
 
 	err = db.GetBulkBytes(ctx, testKeys)
@@ -393,7 +435,7 @@ func TestGetBulkBytesLargeValue(t *testing.T) {
 	}
 
 	for k, v := range baseKeys {
-		db.set(ctx, k, v)
+		db.Set(ctx, k, v, time.Time{})
 		testKeys[k] = []byte("")
 	}
 
@@ -472,3 +514,79 @@ func TestIsError(t *testing.T) {
 		t.Error("IsError returns false")
 	}
 }
+
+func TestScan(t *testing.T) {
+	ctx := context.Background()
+	cmd := startServer(t)
+	defer haltServer(cmd, t)
+
+	db, err := NewConn(KTHOST, KTPORT, 1, DEFAULT_TIMEOUT)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	values := map[string]string{"a": "a"}
+	for i := 0; i < 300; i++ {
+		values[fmt.Sprintf("p%03d", i)] = strconv.Itoa(i)
+	}
+	if _, err := db.SetBulk(ctx, values, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	err = db.Scan(ctx, "p", func(key string, value []byte) error {
+		if key != fmt.Sprintf("p%03d", n) || string(value) != strconv.Itoa(n) {
+			t.Fatalf("Scan returned %s %s for record %d.", key, value, n)
+		}
+		n++
+		return nil
+	})
+	if err != nil || n != 300 {
+		t.Errorf("Scan failed: %d %v", n, err)
+	}
+
+	cur := db.NewCursor(ctx)
+	defer cur.Close()
+	for cur.Next() {
+		if cur.Key() != "a" {
+			if err := cur.Remove(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := db.Count(ctx); count != 1 {
+		t.Errorf("Cursor removal failed: want 1 record, got %d.", count)
+	}
+}
+
+func TestBinaryBulk(t *testing.T) {
+	ctx := context.Background()
+	cmd := startServer(t)
+	defer haltServer(cmd, t)
+
+	db, err := NewConnBinary(KTHOST, KTPORT, 1, DEFAULT_TIMEOUT)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	values := map[string]string{"a": "a", "b": "\x00\xff"}
+	if n, err := db.SetBulk(ctx, values, time.Time{}); err != nil || n != 2 {
+		t.Fatalf("SetBulk failed: %d %v", n, err)
+	}
+	if got, _ := db.Get(ctx, "b"); got != "\x00\xff" {
+		t.Errorf("Get failed: want binary data, got %q.", got)
+	}
+	keys := map[string]string{"a": "", "b": "", "c": ""}
+	if err := db.GetBulk(ctx, keys); err != nil || !reflect.DeepEqual(keys, values) {
+		t.Errorf("GetBulk failed: want %v, got %v %v.", values, keys, err)
+	}
+	if n, err := db.RemoveBulk(ctx, []string{"a", "c"}); err != nil || n != 1 {
+		t.Errorf("RemoveBulk failed: %d %v", n, err)
+	}
+	if count, _ := db.Count(ctx); count != 1 {
+		t.Errorf("Count failed: want 1, got %d.", count)
+	}
+}
@@ -0,0 +1,378 @@
+package kt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// KT also speaks a binary protocol on the same port, for the bulk
+// operations and play_script only. It saves the HTTP headers and
+// the TSV encoding, base64 for any binary data. Requests start with a
+// magic byte, which is echoed in the response, or replaced by
+// binaryError if the operation failed. All the integers are big
+// endian.
+//
+//	set_bulk:    magic flags:4 rnum:4 (dbidx:2 ksiz:4 vsiz:4 xt:8 key value)*
+//	             magic hits:4
+//	get_bulk:    magic flags:4 rnum:4 (dbidx:2 ksiz:4 key)*
+//	             magic hits:4 (dbidx:2 ksiz:4 vsiz:4 xt:8 key value)*
+//	remove_bulk: magic flags:4 rnum:4 (dbidx:2 ksiz:4 key)*
+//	             magic hits:4
+//	play_script: magic flags:4 nsiz:4 rnum:4 name (ksiz:4 vsiz:4 key value)*
+//	             magic rnum:4 (ksiz:4 vsiz:4 key value)*
+const (
+	binaryPlayScript = 0xB4
+	binarySetBulk    = 0xB8
+	binaryRemoveBulk = 0xB9
+	binaryGetBulk    = 0xBA
+	binaryError      = 0xBF
+)
+
+// The xt of records without expiry.
+const binaryNoExpire = math.MaxInt64
+
+// Idle connections are closed rather than reused after this long, KT
+// may have closed them already.
+const binaryIdleTimeout = 30 * time.Second
+
+// Records of a response larger than this are taken for a corrupted
+// response, rather than allocated.
+const binaryMaxRecord = 256 << 20
+
+// The KT procedures of the magic bytes.
+var binaryOps = map[byte]string{
+	binaryPlayScript: "play_script",
+	binarySetBulk:    "set_bulk",
+	binaryRemoveBulk: "remove_bulk",
+	binaryGetBulk:    "get_bulk",
+}
+
+// Errors of exchange, wrapped in an Error with the operation by
+// doBinary.
+var (
+	errBinaryProtocol = errors.New("binary protocol: unexpected response")
+	// The server answered binaryError, the connection is still usable.
+	errBinaryFailed = errors.New("binary protocol: operation failed")
+)
+
+// A pooled connection speaking the binary protocol.
+type binaryConn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	lastUsed time.Time
+}
+
+// binaryPool is the pool of connections of a Conn for the binary
+// protocol.
+type binaryPool struct {
+	network string
+	address string
+	timeout time.Duration
+	dialer  net.Dialer
+	idle    chan *binaryConn
+}
+
+func newBinaryPool(network, address string, poolsize int, timeout time.Duration) *binaryPool {
+	return &binaryPool{
+		network: network,
+		address: address,
+		timeout: timeout,
+		dialer:  net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second},
+		idle:    make(chan *binaryConn, poolsize),
+	}
+}
+
+// NewConnBinary creates a connection to a Kyoto Tycoon endpoint like
+// NewConn, which uses the binary protocol for SetBulk, GetBulk,
+// GetBulkBytes, RemoveBulk and PlayScript, with a pool of poolsize
+// connections of its own. The other operations use HTTP.
+func NewConnBinary(host string, port int, poolsize int, timeout time.Duration) (*Conn, error) {
+	c, err := NewConn(host, port, poolsize, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(host, "unix://") {
+		c.binary = newBinaryPool("unix", c.host, poolsize, timeout)
+	} else {
+		c.binary = newBinaryPool("tcp", net.JoinHostPort(host, strconv.Itoa(port)), poolsize, timeout)
+	}
+	return c, nil
+}
+
+// Take an idle connection, or dial a new one. reused tells whether the
+// connection was idle, so it may have been closed by the server.
+func (p *binaryPool) get(ctx context.Context) (bc *binaryConn, reused bool, err error) {
+	for {
+		select {
+		case bc = <-p.idle:
+			if time.Since(bc.lastUsed) < binaryIdleTimeout {
+				return bc, true, nil
+			}
+			bc.conn.Close()
+		default:
+			conn, err := p.dialer.DialContext(ctx, p.network, p.address)
+			if err != nil {
+				return nil, false, err
+			}
+			return &binaryConn{conn: conn, r: bufio.NewReader(conn)}, false, nil
+		}
+	}
+}
+
+// Return the connection to the pool, or close it if the pool is full.
+func (p *binaryPool) put(bc *binaryConn) {
+	bc.lastUsed = time.Now()
+	select {
+	case p.idle <- bc:
+	default:
+		bc.conn.Close()
+	}
+}
+
+// Send the request and read the response with read, which is given
+// the reader after the magic byte. The request is sent again on a new
+// connection if an idle one fails, like roundTrip does.
+func (c *Conn) doBinary(ctx context.Context, req []byte, read func(r *bufio.Reader) error) error {
+	p := c.binary
+	op := binaryOps[req[0]]
+	for {
+		if err := ctx.Err(); err != nil {
+			return opError(op, "", err)
+		}
+		bc, reused, err := p.get(ctx)
+		if err != nil {
+			return opError(op, "", err)
+		}
+		err = bc.exchange(ctx, p.timeout, req, read)
+		if err == nil {
+			p.put(bc)
+			return nil
+		}
+		if err == errBinaryFailed {
+			// KT doesn't tell why, the HTTP API answers the
+			// same failures with status 500.
+			p.put(bc)
+			return &Error{Class: ClassImplementation, Op: op, Err: err}
+		}
+		bc.conn.Close()
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() {
+			return ErrTimeout
+		}
+		if err == errBinaryProtocol {
+			return &Error{Class: ClassImplementation, Op: op, Err: err}
+		}
+		if !reused || ctx.Err() != nil {
+			return opError(op, "", err)
+		}
+		atomic.AddUint64(&c.retryCount, 1)
+	}
+}
+
+// Returns ctx.Err() if the context is done before the response is
+// read, the connection is closed then, same as the HTTP requests are
+// cancelled.
+func (bc *binaryConn) exchange(ctx context.Context, timeout time.Duration, req []byte, read func(r *bufio.Reader) error) (err error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	bc.conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		bc.conn.Close()
+	})
+	defer func() {
+		if !stop() {
+			err = ctx.Err()
+		}
+	}()
+	if _, err := bc.conn.Write(req); err != nil {
+		return err
+	}
+	magic, err := bc.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch magic {
+	case req[0]:
+		return read(bc.r)
+	case binaryError:
+		return errBinaryFailed
+	}
+	return errBinaryProtocol
+}
+
+func readUint32(r *bufio.Reader) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+// Read the key and the value of a record of the response.
+func readRecord(r *bufio.Reader, ksiz, vsiz uint32) (string, []byte, error) {
+	if uint64(ksiz)+uint64(vsiz) > binaryMaxRecord {
+		return "", nil, errBinaryProtocol
+	}
+	rec := make([]byte, int(ksiz)+int(vsiz))
+	if _, err := io.ReadFull(r, rec); err != nil {
+		return "", nil, err
+	}
+	return string(rec[:ksiz]), rec[ksiz:], nil
+}
+
+// Read the hits of set_bulk and remove_bulk.
+func readHits(hits *int64) func(r *bufio.Reader) error {
+	return func(r *bufio.Reader) error {
+		n, err := readUint32(r)
+		*hits = int64(n)
+		return err
+	}
+}
+
+// Start a request with the magic byte, no flags and the record count.
+func binaryRequest(magic byte, size int, rnum int) []byte {
+	req := make([]byte, 0, size)
+	req = append(req, magic)
+	req = binary.BigEndian.AppendUint32(req, 0)
+	return binary.BigEndian.AppendUint32(req, uint32(rnum))
+}
+
+func (c *Conn) binarySetBulk(ctx context.Context, values map[string]string, expire time.Time) (int64, error) {
+	xt := int64(binaryNoExpire)
+	if !expire.IsZero() {
+		xt = -expire.Unix()
+	}
+	size := 9
+	for k, v := range values {
+		size += 18 + len(k) + len(v)
+	}
+	req := binaryRequest(binarySetBulk, size, len(values))
+	for k, v := range values {
+		req = binary.BigEndian.AppendUint16(req, 0)
+		req = binary.BigEndian.AppendUint32(req, uint32(len(k)))
+		req = binary.BigEndian.AppendUint32(req, uint32(len(v)))
+		req = binary.BigEndian.AppendUint64(req, uint64(xt))
+		req = append(req, k...)
+		req = append(req, v...)
+	}
+	var hits int64
+	err := c.doBinary(ctx, req, readHits(&hits))
+	return hits, err
+}
+
+// Request with only the keys, for get_bulk and remove_bulk.
+func binaryKeysRequest(magic byte, keys []string) []byte {
+	size := 9
+	for _, k := range keys {
+		size += 6 + len(k)
+	}
+	req := binaryRequest(magic, size, len(keys))
+	for _, k := range keys {
+		req = binary.BigEndian.AppendUint16(req, 0)
+		req = binary.BigEndian.AppendUint32(req, uint32(len(k)))
+		req = append(req, k...)
+	}
+	return req
+}
+
+func (c *Conn) binaryRemoveBulk(ctx context.Context, keys []string) (int64, error) {
+	var hits int64
+	err := c.doBinary(ctx, binaryKeysRequest(binaryRemoveBulk, keys), readHits(&hits))
+	return hits, err
+}
+
+func (c *Conn) binaryGetBulkBytes(ctx context.Context, keys map[string][]byte) error {
+	list := make([]string, 0, len(keys))
+	for k := range keys {
+		list = append(list, k)
+	}
+	found := make(map[string][]byte, len(keys))
+	err := c.doBinary(ctx, binaryKeysRequest(binaryGetBulk, list), func(r *bufio.Reader) error {
+		hits, err := readUint32(r)
+		if err != nil {
+			return err
+		}
+		var head [18]byte
+		for i := uint32(0); i < hits; i++ {
+			if _, err := io.ReadFull(r, head[:]); err != nil {
+				return err
+			}
+			key, value, err := readRecord(r, binary.BigEndian.Uint32(head[2:]), binary.BigEndian.Uint32(head[6:]))
+			if err != nil {
+				return err
+			}
+			found[key] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for k := range keys {
+		if v, ok := found[k]; ok {
+			keys[k] = v
+		} else {
+			delete(keys, k)
+		}
+	}
+	return nil
+}
+
+func (c *Conn) binaryPlayScript(ctx context.Context, name string, args map[string][]byte) (map[string][]byte, error) {
+	size := 13 + len(name)
+	for k, v := range args {
+		size += 8 + len(k) + len(v)
+	}
+	req := make([]byte, 0, size)
+	req = append(req, binaryPlayScript)
+	req = binary.BigEndian.AppendUint32(req, 0)
+	req = binary.BigEndian.AppendUint32(req, uint32(len(name)))
+	req = binary.BigEndian.AppendUint32(req, uint32(len(args)))
+	req = append(req, name...)
+	for k, v := range args {
+		req = binary.BigEndian.AppendUint32(req, uint32(len(k)))
+		req = binary.BigEndian.AppendUint32(req, uint32(len(v)))
+		req = append(req, k...)
+		req = append(req, v...)
+	}
+	var res map[string][]byte
+	err := c.doBinary(ctx, req, func(r *bufio.Reader) error {
+		rnum, err := readUint32(r)
+		if err != nil {
+			return err
+		}
+		// Don't trust rnum to size the map, a corrupted one
+		// could be huge.
+		res = make(map[string][]byte)
+		var head [8]byte
+		for i := uint32(0); i < rnum; i++ {
+			if _, err := io.ReadFull(r, head[:]); err != nil {
+				return err
+			}
+			key, value, err := readRecord(r, binary.BigEndian.Uint32(head[:]), binary.BigEndian.Uint32(head[4:]))
+			if err != nil {
+				return err
+			}
+			res[key] = value
+		}
+		return nil
+	})
+	if errors.Is(err, errBinaryFailed) {
+		err = ErrScriptFailed
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,267 @@
+package kt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// Cursor walks the records of the database in key order, with the
+// cur_* procedures.
+//
+// KT keeps the cursors in the session, that is the HTTP connection,
+// so each Cursor uses a connection of its own rather than the pool of
+// the Conn. If that connection breaks the cursor is lost, and the
+// calls fail with ErrCursorLost until the next Jump or JumpBack. A
+// Cursor isn't safe for concurrent use and must be closed.
+//
+//	cur := db.NewCursor(ctx)
+//	defer cur.Close()
+//	for cur.Next() {
+//		fmt.Println(cur.Key(), cur.Value())
+//	}
+//	if err := cur.Err(); err != nil {
+//		...
+//	}
+type Cursor struct {
+	conn *Conn
+	ctx  context.Context
+	id   []byte
+
+	// The cursor was moved to a record yet to be returned by Next.
+	fresh bool
+	// The cursor was moved at all.
+	positioned bool
+	// The connection of the last jump, and whether it succeeded.
+	session net.Conn
+	jumped  bool
+
+	key   string
+	value []byte
+	err   error
+}
+
+// ErrCursorLost is returned by the methods of a Cursor when its
+// connection was replaced since the last jump, KT dropped the cursor
+// with the old one.
+var ErrCursorLost = &Error{Message: "cursor lost with its connection", Class: ClassNetwork}
+
+var cursorID int64
+
+// NewCursor creates a cursor on the database, not pointing to any
+// record. The context is used for all the calls made by the cursor.
+func (c *Conn) NewCursor(ctx context.Context) *Cursor {
+	transport := c.transport.Clone()
+	transport.MaxConnsPerHost = 1
+	transport.MaxIdleConnsPerHost = 1
+	// Keep the connection as long as the cursor.
+	transport.IdleConnTimeout = 0
+	return &Cursor{
+		conn: &Conn{
+			scheme:    c.scheme,
+			timeout:   c.timeout,
+			host:      c.host,
+			transport: transport,
+			noRetry:   true,
+		},
+		ctx: ctx,
+		id:  []byte(strconv.FormatInt(atomic.AddInt64(&cursorID, 1), 10)),
+	}
+}
+
+// Call the cursor procedure. 450 answers, when there is no record to
+// move to or the cursor doesn't point to any, are turned into
+// ErrNotFound. Calls other than jumps made on another connection than
+// the last jump fail with ErrCursorLost.
+func (cur *Cursor) call(op, name string, values ...KV) ([]KV, error) {
+	span, ctx := opentracing.StartSpanFromContext(cur.ctx, "ktrpc Cursor"+op)
+	defer span.Finish()
+
+	var session net.Conn
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			session = info.Conn
+		},
+	})
+	values = append([]KV{{"CUR", cur.id}}, values...)
+	m, err := cur.conn.doCall(ctx, name, values, ErrNotFound)
+	switch {
+	case name == "cur_jump" || name == "cur_jump_back":
+		cur.session = session
+		cur.jumped = err == nil
+	case cur.session != nil && session != nil && session != cur.session:
+		m, err = nil, ErrCursorLost
+	}
+	if err != nil {
+		span.SetTag("status", err)
+	}
+	return m, err
+}
+
+func (cur *Cursor) jump(op, name, key string) error {
+	var values []KV
+	if key != "" {
+		values = append(values, KV{"key", []byte(key)})
+	}
+	_, err := cur.call(op, name, values...)
+	cur.positioned = true
+	cur.fresh = err == nil
+	return err
+}
+
+// Whether err is the end of the records rather than a failure: a 450
+// answer to a jump, or to a call after a successful jump on the same
+// connection.
+func (cur *Cursor) atEnd(err error, jump bool) bool {
+	return errors.Is(err, ErrNotFound) && (jump || cur.jumped)
+}
+
+// Jump moves the cursor to the first record with a key equal to or
+// greater than key, or to the first record if key is empty.
+// ErrNotFound is returned if there is no such record.
+func (cur *Cursor) Jump(key string) error {
+	return cur.jump("Jump", "cur_jump", key)
+}
+
+// JumpBack moves the cursor to the last record with a key equal to or
+// less than key, or to the last record if key is empty. ErrNotFound
+// is returned if there is no such record. Not all the database types
+// support it.
+func (cur *Cursor) JumpBack(key string) error {
+	return cur.jump("JumpBack", "cur_jump_back", key)
+}
+
+// Step moves the cursor to the next record. ErrNotFound is returned
+// if there is none.
+func (cur *Cursor) Step() error {
+	_, err := cur.call("Step", "cur_step")
+	cur.fresh = err == nil
+	return err
+}
+
+// Get returns the key and the data of the record the cursor points to.
+func (cur *Cursor) Get() (string, []byte, error) {
+	m, err := cur.call("Get", "cur_get")
+	if err != nil {
+		return "", nil, err
+	}
+	return string(findRec(m, "key").Value), findRec(m, "value").Value, nil
+}
+
+// GetKey returns the key of the record the cursor points to.
+func (cur *Cursor) GetKey() (string, error) {
+	m, err := cur.call("GetKey", "cur_get_key")
+	if err != nil {
+		return "", err
+	}
+	return string(findRec(m, "key").Value), nil
+}
+
+// SetValue replaces the data of the record the cursor points to. The
+// record expires at expire, unless it's zero.
+func (cur *Cursor) SetValue(value []byte, expire time.Time) error {
+	_, err := cur.call("SetValue", "cur_set_value", withExpire([]KV{{"value", value}}, expire)...)
+	return err
+}
+
+// Remove removes the record the cursor points to, and moves the cursor
+// to the next record.
+func (cur *Cursor) Remove() error {
+	_, err := cur.call("Remove", "cur_remove")
+	cur.fresh = err == nil
+	return err
+}
+
+// Next moves the cursor to the next record, or to the first one if
+// it wasn't moved yet, and reads it. It returns false at the end of
+// the database or on error, see Err.
+func (cur *Cursor) Next() bool {
+	if cur.err != nil {
+		return false
+	}
+	var err error
+	jump := !cur.positioned
+	if jump {
+		err = cur.Jump("")
+	} else if !cur.fresh {
+		err = cur.Step()
+	}
+	if err == nil {
+		jump = false
+		cur.key, cur.value, err = cur.Get()
+		cur.fresh = false
+	}
+	if err != nil {
+		if !cur.atEnd(err, jump) {
+			cur.err = err
+		}
+		cur.key, cur.value = "", nil
+		return false
+	}
+	return true
+}
+
+// Key returns the key of the record read by Next.
+func (cur *Cursor) Key() string {
+	return cur.key
+}
+
+// Value returns the data of the record read by Next.
+func (cur *Cursor) Value() []byte {
+	return cur.value
+}
+
+// Err returns the error that stopped Next, if any.
+func (cur *Cursor) Err() error {
+	return cur.err
+}
+
+// Close deletes the cursor on the server and closes its connection.
+func (cur *Cursor) Close() error {
+	_, err := cur.call("Delete", "cur_delete")
+	cur.conn.transport.CloseIdleConnections()
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrCursorLost) {
+		// There is nothing to delete.
+		err = nil
+	}
+	return err
+}
+
+// Scan calls fn with the records with keys starting with prefix, in
+// key order, or with all the records if prefix is empty. It stops at
+// the first error returned by fn and returns it.
+func (c *Conn) Scan(ctx context.Context, prefix string, fn func(key string, value []byte) error) error {
+	cur := c.NewCursor(ctx)
+	defer cur.Close()
+
+	err := cur.Jump(prefix)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	for err == nil {
+		var m []KV
+		// Read and step in one call.
+		m, err = cur.call("Get", "cur_get", KV{"step", nil})
+		if err != nil {
+			break
+		}
+		key := string(findRec(m, "key").Value)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		if err := fn(key, findRec(m, "value").Value); err != nil {
+			return err
+		}
+	}
+	if cur.atEnd(err, false) {
+		return nil
+	}
+	return err
+}
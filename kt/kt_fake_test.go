@@ -0,0 +1,869 @@
+package kt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is a stand-in for ktserver checking what the client
+// sends. Handler answers the requests other than /rpc/void.
+type fakeServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	requests []*http.Request
+	bodies   []string
+	handler  http.HandlerFunc
+}
+
+func startFakeServer(t testing.TB, handler http.HandlerFunc) (*fakeServer, *Conn) {
+	s := &fakeServer{handler: handler}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rpc/void" {
+			w.Header().Set("Content-Type", "text/tab-separated-values")
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		s.lock.Lock()
+		s.requests = append(s.requests, r)
+		// TSVEncode sizes the buffer for base64 and leaves the
+		// rest of it zeroed.
+		s.bodies = append(s.bodies, strings.TrimRight(string(body), "\x00"))
+		s.lock.Unlock()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		s.handler(w, r)
+	}))
+
+	host, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	db, err := NewConn(host, p, 1, DEFAULT_TIMEOUT)
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	return s, db
+}
+
+// Last request and its body.
+func (s *fakeServer) last() (*http.Request, string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	n := len(s.requests) - 1
+	return s.requests[n], s.bodies[n]
+}
+
+// Respond to an RPC call with the TSV records.
+func writeTSV(w http.ResponseWriter, code int, kvs ...KV) {
+	body, enc := TSVEncode(kvs)
+	if enc == Base64Enc {
+		w.Header().Set("Content-Type", "text/tab-separated-values; colenc=B")
+	} else {
+		w.Header().Set("Content-Type", "text/tab-separated-values")
+	}
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+func TestFakeSetExpire(t *testing.T) {
+	ctx := context.Background()
+	s, db := startFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(201)
+	})
+	defer s.Close()
+
+	if err := db.Set(ctx, "a", []byte("va"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	r, body := s.last()
+	if r.Method != "PUT" || r.URL.Path != "/a" || body != "va" {
+		t.Errorf("unexpected request %s %s %q", r.Method, r.URL.Path, body)
+	}
+	if xt := r.Header.Get("X-Kt-Xt"); xt != "" {
+		t.Errorf("expecting no expiry, got %q", xt)
+	}
+
+	expire := time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	if err := db.Set(ctx, "a", []byte("va"), expire); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = s.last()
+	if xt := r.Header.Get("X-Kt-Xt"); xt != "Wed, 02 Jan 2030 02:04:05 GMT" {
+		t.Errorf("unexpected expiry %q", xt)
+	}
+}
+
+func TestFakeSetBulkExpire(t *testing.T) {
+	ctx := context.Background()
+	s, db := startFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeTSV(w, 200, KV{"num", []byte("1")})
+	})
+	defer s.Close()
+
+	expire := time.Unix(1893553445, 0)
+	n, err := db.SetBulk(ctx, map[string]string{"a": "va"}, expire)
+	if err != nil || n != 1 {
+		t.Fatalf("unexpected result %d %v", n, err)
+	}
+	r, body := s.last()
+	if r.URL.Path != "/rpc/set_bulk" || body != "xt\t-1893553445\n_a\tva\n" {
+		t.Errorf("unexpected request %s %q", r.URL.Path, body)
+	}
+
+	if _, err := db.SetBulk(ctx, map[string]string{"a": "va"}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, body := s.last(); body != "_a\tva\n" {
+		t.Errorf("expecting no expiry, got %q", body)
+	}
+}
+
+func TestFakeRemove(t *testing.T) {
+	ctx := context.Background()
+	s, db := startFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rpc/remove_bulk" {
+			writeTSV(w, 200, KV{"num", []byte("2")})
+		} else if r.URL.Path == "/a" {
+			w.WriteHeader(204)
+		} else {
+			w.WriteHeader(404)
+		}
+	})
+	defer s.Close()
+
+	if err := db.Remove(ctx, "a"); err != nil {
+		t.Error(err)
+	}
+	if r, _ := s.last(); r.Method != "DELETE" {
+		t.Errorf("unexpected method %s", r.Method)
+	}
+	if err := db.Remove(ctx, "b"); err != ErrNotFound {
+		t.Errorf("expecting ErrNotFound, got %v", err)
+	}
+	if n, err := db.RemoveBulk(ctx, []string{"a", "b", "c"}); err != nil || n != 2 {
+		t.Errorf("unexpected result %d %v", n, err)
+	}
+}
+
+// Parse the TSV body of an RPC call.
+func decodeBody(t testing.TB, r *http.Request, body string) map[string]string {
+	m, err := DecodeValues([]byte(body), r.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[string]string, len(m))
+	for _, kv := range m {
+		res[kv.Key] = string(kv.Value)
+	}
+	return res
+}
+
+func TestFakeRPC(t *testing.T) {
+	ctx := context.Background()
+	var code int
+	var out []KV
+	s, db := startFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		writeTSV(w, code, out...)
+	})
+	defer s.Close()
+	expire := time.Unix(1893553445, 0)
+
+	var tests = []struct {
+		name string
+		code int
+		out  []KV
+		call func() (interface{}, error)
+		in   map[string]string
+		want interface{}
+		err  error
+	}{
+		{
+			name: "add",
+			code: 450,
+			call: func() (interface{}, error) { return nil, db.Add(ctx, "a", []byte("va"), expire) },
+			in:   map[string]string{"key": "a", "value": "va", "xt": "-1893553445"},
+			err:  ErrExists,
+		},
+		{
+			name: "replace",
+			code: 450,
+			call: func() (interface{}, error) { return nil, db.Replace(ctx, "a", []byte("va"), time.Time{}) },
+			in:   map[string]string{"key": "a", "value": "va"},
+			err:  ErrNotFound,
+		},
+		{
+			name: "append",
+			code: 200,
+			call: func() (interface{}, error) { return nil, db.Append(ctx, "a", []byte("va"), time.Time{}) },
+			in:   map[string]string{"key": "a", "value": "va"},
+		},
+		{
+			name: "increment",
+			code: 200,
+			out:  []KV{{"num", []byte("42")}},
+			call: func() (interface{}, error) { return db.Increment(ctx, "a", 2, time.Time{}) },
+			in:   map[string]string{"key": "a", "num": "2"},
+			want: int64(42),
+		},
+		{
+			name: "increment",
+			code: 450,
+			call: func() (interface{}, error) { return db.Increment(ctx, "a", 2, time.Time{}) },
+			in:   map[string]string{"key": "a", "num": "2"},
+			want: int64(0),
+			err:  ErrIncompatible,
+		},
+		{
+			name: "increment_double",
+			code: 200,
+			out:  []KV{{"num", []byte("2.500000")}},
+			call: func() (interface{}, error) { return db.IncrementDouble(ctx, "a", 0.5, time.Time{}) },
+			in:   map[string]string{"key": "a", "num": "0.5"},
+			want: 2.5,
+		},
+		{
+			name: "cas",
+			code: 450,
+			call: func() (interface{}, error) { return nil, db.CAS(ctx, "a", []byte("old"), nil, time.Time{}) },
+			in:   map[string]string{"key": "a", "oval": "old"},
+			err:  ErrCASMismatch,
+		},
+		{
+			name: "seize",
+			code: 200,
+			out:  []KV{{"value", []byte("va")}},
+			call: func() (interface{}, error) {
+				v, err := db.Seize(ctx, "a")
+				return string(v), err
+			},
+			in:   map[string]string{"key": "a"},
+			want: "va",
+		},
+		{
+			name: "match_regex",
+			code: 200,
+			out:  []KV{{"_a", []byte("")}, {"_b", []byte("")}, {"num", []byte("2")}},
+			call: func() (interface{}, error) { return db.MatchRegex(ctx, "^[ab]$", 10) },
+			in:   map[string]string{"regex": "^[ab]$", "max": "10"},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "match_similar",
+			code: 200,
+			out:  []KV{{"_b", []byte("0")}, {"_a", []byte("1")}, {"num", []byte("2")}},
+			call: func() (interface{}, error) { return db.MatchSimilar(ctx, "b", 1, true, 10) },
+			in:   map[string]string{"origin": "b", "range": "1", "utf": "true", "max": "10"},
+			want: []string{"b", "a"},
+		},
+		{
+			name: "clear",
+			code: 200,
+			call: func() (interface{}, error) { return nil, db.Clear(ctx) },
+			in:   map[string]string{},
+		},
+		{
+			name: "synchronize",
+			code: 450,
+			call: func() (interface{}, error) { return nil, db.Synchronize(ctx, true, "backup") },
+			in:   map[string]string{"hard": "true", "command": "backup"},
+			err:  ErrSyncCommand,
+		},
+		{
+			name: "vacuum",
+			code: 500,
+			out:  []KV{{"ERROR", []byte("oops")}},
+			call: func() (interface{}, error) { return nil, db.Vacuum(ctx, 10) },
+			in:   map[string]string{"step": "10"},
+			err:  &Error{Message: "oops", Code: 500, Class: ClassImplementation, Op: "vacuum"},
+		},
+		{
+			name: "report",
+			code: 200,
+			out:  []KV{{"count", []byte("3")}},
+			call: func() (interface{}, error) { return db.Report(ctx) },
+			in:   map[string]string{},
+			want: map[string]string{"count": "3"},
+		},
+		{
+			name: "play_script",
+			code: 200,
+			out:  []KV{{"_x", []byte("1")}, {"y", []byte("2")}},
+			call: func() (interface{}, error) {
+				return db.PlayScript(ctx, "f", map[string][]byte{"arg": []byte("\x00")})
+			},
+			in:   map[string]string{"name": "f", "_arg": "\x00"},
+			want: map[string][]byte{"x": []byte("1")},
+		},
+		{
+			name: "play_script",
+			code: 450,
+			call: func() (interface{}, error) { return db.PlayScript(ctx, "f", nil) },
+			in:   map[string]string{"name": "f"},
+			want: map[string][]byte(nil),
+			err:  ErrScriptFailed,
+		},
+	}
+	for _, tt := range tests {
+		code, out = tt.code, tt.out
+		got, err := tt.call()
+		if !reflect.DeepEqual(err, tt.err) {
+			t.Errorf("%s: want error %v, got %v", tt.name, tt.err, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: want %#v, got %#v", tt.name, tt.want, got)
+		}
+		r, body := s.last()
+		if r.URL.Path != "/rpc/"+tt.name {
+			t.Errorf("%s: unexpected path %s", tt.name, r.URL.Path)
+		}
+		if in := decodeBody(t, r, body); !reflect.DeepEqual(in, tt.in) {
+			t.Errorf("%s: want parameters %v, got %v", tt.name, tt.in, in)
+		}
+	}
+}
+
+// fakeCursors answers the cursor procedures over sorted records,
+// keeping the cursors of each connection apart like ktserver does.
+type fakeCursors struct {
+	lock    sync.Mutex
+	keys    []string
+	values  map[string]string
+	cursors map[string]int
+}
+
+func newFakeCursors(values map[string]string) *fakeCursors {
+	f := &fakeCursors{values: values, cursors: make(map[string]int)}
+	for k := range values {
+		f.keys = append(f.keys, k)
+	}
+	sort.Strings(f.keys)
+	return f
+}
+
+func (f *fakeCursors) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	m, _ := DecodeValues(bytes.TrimRight(body, "\x00"), r.Header.Get("Content-Type"))
+	in := make(map[string]string)
+	for _, kv := range m {
+		in[kv.Key] = string(kv.Value)
+	}
+	id := r.RemoteAddr + "/" + in["CUR"]
+	pos, ok := f.cursors[id]
+	if !ok {
+		pos = -1
+	}
+	_, hasKey := in["key"]
+	switch r.URL.Path {
+	case "/rpc/cur_jump":
+		pos = sort.SearchStrings(f.keys, in["key"])
+	case "/rpc/cur_jump_back":
+		pos = len(f.keys) - 1
+		if hasKey {
+			pos = sort.SearchStrings(f.keys, in["key"]+"\x00") - 1
+		}
+	case "/rpc/cur_step":
+		pos++
+	case "/rpc/cur_get", "/rpc/cur_get_key":
+		if pos < 0 || pos >= len(f.keys) {
+			break
+		}
+		key := f.keys[pos]
+		if _, step := in["step"]; step {
+			f.cursors[id] = pos + 1
+		}
+		if r.URL.Path == "/rpc/cur_get" {
+			writeTSV(w, 200, KV{"key", []byte(key)}, KV{"value", []byte(f.values[key])})
+		} else {
+			writeTSV(w, 200, KV{"key", []byte(key)})
+		}
+		return
+	case "/rpc/cur_set_value":
+		if pos >= 0 && pos < len(f.keys) {
+			f.values[f.keys[pos]] = in["value"]
+		}
+	case "/rpc/cur_remove":
+		if pos >= 0 && pos < len(f.keys) {
+			delete(f.values, f.keys[pos])
+			f.keys = append(f.keys[:pos], f.keys[pos+1:]...)
+			f.cursors[id] = pos
+			writeTSV(w, 200)
+			return
+		}
+	case "/rpc/cur_delete":
+		if !ok {
+			writeTSV(w, 450)
+			return
+		}
+		delete(f.cursors, id)
+		writeTSV(w, 200)
+		return
+	}
+	f.cursors[id] = pos
+	if pos < 0 || pos >= len(f.keys) {
+		writeTSV(w, 450, KV{"ERROR", []byte("DB: 7: no record")})
+		return
+	}
+	writeTSV(w, 200)
+}
+
+func TestFakeCursor(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeCursors(map[string]string{"a": "va", "b": "vb", "c": "vc", "d": "vd"})
+	s, db := startFakeServer(t, f.ServeHTTP)
+	defer s.Close()
+
+	cur := db.NewCursor(ctx)
+	var keys []string
+	for cur.Next() {
+		if string(cur.Value()) != "v"+cur.Key() {
+			t.Errorf("unexpected value %q for %q", cur.Value(), cur.Key())
+		}
+		keys = append(keys, cur.Key())
+		if cur.Key() == "b" {
+			if err := cur.Remove(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if cur.Key() == "c" {
+			if err := cur.SetValue([]byte("vc"), time.Time{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "b", "c", "d"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	if !reflect.DeepEqual(f.keys, []string{"a", "c", "d"}) {
+		t.Errorf("expecting B to be removed, got %v", f.keys)
+	}
+
+	if err := cur.JumpBack("b"); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := cur.GetKey(); err != nil || key != "a" {
+		t.Errorf("unexpected key %q %v", key, err)
+	}
+	if err := cur.Jump("e"); err != ErrNotFound {
+		t.Errorf("expecting ErrNotFound, got %v", err)
+	}
+	if err := cur.Close(); err != nil {
+		t.Error(err)
+	}
+	if len(f.cursors) != 0 {
+		t.Errorf("expecting the cursor to be deleted, got %v", f.cursors)
+	}
+}
+
+func TestFakeCursorLost(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeCursors(map[string]string{"a": "va", "b": "vb", "c": "vc"})
+	s, db := startFakeServer(t, f.ServeHTTP)
+	defer s.Close()
+
+	cur := db.NewCursor(ctx)
+	defer cur.Close()
+	if !cur.Next() || cur.Key() != "a" {
+		t.Fatal("expecting the first record")
+	}
+	// The server forgets the cursor with the connection.
+	s.CloseClientConnections()
+	for cur.Next() {
+		t.Errorf("unexpected record %q", cur.Key())
+	}
+	if cur.Err() == nil {
+		t.Error("expecting the lost cursor to be reported")
+	}
+	if err := cur.Step(); !errors.Is(err, ErrCursorLost) {
+		t.Errorf("expecting ErrCursorLost, got %v", err)
+	}
+
+	if err := cur.Jump("b"); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := cur.GetKey(); err != nil || key != "b" {
+		t.Errorf("unexpected key %q %v", key, err)
+	}
+
+	n := 0
+	err := db.Scan(ctx, "", func(key string, value []byte) error {
+		n++
+		s.CloseClientConnections()
+		return nil
+	})
+	if err == nil || n != 1 {
+		t.Errorf("expecting the scan to fail, got %d %v", n, err)
+	}
+}
+
+func TestFakeScan(t *testing.T) {
+	ctx := context.Background()
+	values := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		values[fmt.Sprintf("p%04d", i)] = strconv.Itoa(i)
+	}
+	values["a"] = "before"
+	values["q"] = "after"
+	f := newFakeCursors(values)
+	s, db := startFakeServer(t, f.ServeHTTP)
+	defer s.Close()
+
+	n := 0
+	err := db.Scan(ctx, "p", func(key string, value []byte) error {
+		if key != fmt.Sprintf("p%04d", n) || string(value) != strconv.Itoa(n) {
+			t.Fatalf("unexpected record %q %q", key, value)
+		}
+		n++
+		return nil
+	})
+	if err != nil || n != 1000 {
+		t.Errorf("unexpected scan %d %v", n, err)
+	}
+
+	n = 0
+	stop := errors.New("stop")
+	err = db.Scan(ctx, "", func(key string, value []byte) error {
+		n++
+		if n == 10 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || n != 10 {
+		t.Errorf("expecting the scan to stop, got %d %v", n, err)
+	}
+	if err := db.Scan(ctx, "r", nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// fakeBinary answers the binary protocol over the records. The
+// script "echo" returns its arguments, the others fail.
+type fakeBinary struct {
+	net.Listener
+	lock   sync.Mutex
+	values map[string][]byte
+	xts    map[string]int64
+	conns  []net.Conn
+}
+
+func startFakeBinary(t testing.TB) *fakeBinary {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeBinary{Listener: l, values: make(map[string][]byte), xts: make(map[string]int64)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			f.lock.Lock()
+			f.conns = append(f.conns, conn)
+			f.lock.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+// Close the connections of the clients.
+func (f *fakeBinary) closeConns() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeBinary) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	u16 := func() uint16 {
+		var b [2]byte
+		io.ReadFull(r, b[:])
+		return binary.BigEndian.Uint16(b[:])
+	}
+	u32 := func() uint32 {
+		var b [4]byte
+		io.ReadFull(r, b[:])
+		return binary.BigEndian.Uint32(b[:])
+	}
+	u64 := func() uint64 {
+		var b [8]byte
+		io.ReadFull(r, b[:])
+		return binary.BigEndian.Uint64(b[:])
+	}
+	str := func(n uint32) []byte {
+		b := make([]byte, n)
+		io.ReadFull(r, b)
+		return b
+	}
+	for {
+		magic, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		u32() // flags
+		res := []byte{magic}
+		f.lock.Lock()
+		switch magic {
+		case binarySetBulk:
+			rnum := u32()
+			for i := uint32(0); i < rnum; i++ {
+				u16()
+				ksiz, vsiz, xt := u32(), u32(), u64()
+				key := string(str(ksiz))
+				f.values[key], f.xts[key] = str(vsiz), int64(xt)
+			}
+			res = binary.BigEndian.AppendUint32(res, rnum)
+		case binaryGetBulk:
+			rnum := u32()
+			var recs []byte
+			hits := uint32(0)
+			for i := uint32(0); i < rnum; i++ {
+				u16()
+				key := str(u32())
+				if v, ok := f.values[string(key)]; ok {
+					hits++
+					recs = binary.BigEndian.AppendUint16(recs, 0)
+					recs = binary.BigEndian.AppendUint32(recs, uint32(len(key)))
+					recs = binary.BigEndian.AppendUint32(recs, uint32(len(v)))
+					recs = binary.BigEndian.AppendUint64(recs, 0)
+					recs = append(append(recs, key...), v...)
+				}
+			}
+			res = append(binary.BigEndian.AppendUint32(res, hits), recs...)
+		case binaryRemoveBulk:
+			rnum := u32()
+			hits := uint32(0)
+			for i := uint32(0); i < rnum; i++ {
+				u16()
+				key := string(str(u32()))
+				if _, ok := f.values[key]; ok {
+					hits++
+					delete(f.values, key)
+				}
+			}
+			res = binary.BigEndian.AppendUint32(res, hits)
+		case binaryPlayScript:
+			nsiz, rnum := u32(), u32()
+			name := string(str(nsiz))
+			res = binary.BigEndian.AppendUint32(res, rnum)
+			for i := uint32(0); i < rnum; i++ {
+				ksiz, vsiz := u32(), u32()
+				res = binary.BigEndian.AppendUint32(res, ksiz)
+				res = binary.BigEndian.AppendUint32(res, vsiz)
+				res = append(append(res, str(ksiz)...), str(vsiz)...)
+			}
+			if name != "echo" {
+				res = []byte{binaryError}
+			}
+		default:
+			res = []byte{binaryError}
+		}
+		f.lock.Unlock()
+		conn.Write(res)
+	}
+}
+
+func TestFakeBinary(t *testing.T) {
+	ctx := context.Background()
+	s, db := startFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected HTTP request %s", r.URL.Path)
+	})
+	defer s.Close()
+	f := startFakeBinary(t)
+	defer f.Close()
+	db.binary = newBinaryPool("tcp", f.Addr().String(), 2, DEFAULT_TIMEOUT)
+
+	expire := time.Unix(1893553445, 0)
+	values := map[string]string{"a": "va", "b": "\x00\xff", "c": ""}
+	if n, err := db.SetBulk(ctx, values, expire); err != nil || n != 3 {
+		t.Fatalf("unexpected result %d %v", n, err)
+	}
+	if xt := f.xts["a"]; xt != -1893553445 {
+		t.Errorf("unexpected xt %d", xt)
+	}
+	db.SetBulk(ctx, map[string]string{"d": "vd"}, time.Time{})
+	if xt := f.xts["d"]; xt != binaryNoExpire {
+		t.Errorf("expecting no expiry, got %d", xt)
+	}
+
+	keys := map[string][]byte{"a": nil, "b": nil, "c": nil, "e": nil}
+	if err := db.GetBulkBytes(ctx, keys); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]byte{"a": []byte("va"), "b": []byte("\x00\xff"), "c": {}}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("unexpected values %q", keys)
+	}
+
+	// The server closed the idle connection.
+	f.closeConns()
+	if n, err := db.RemoveBulk(ctx, []string{"a", "e"}); err != nil || n != 1 {
+		t.Errorf("unexpected result %d %v", n, err)
+	}
+	if db.RetryCount() == 0 {
+		t.Error("expecting a retry")
+	}
+
+	args := map[string][]byte{"x": []byte("1"), "y": []byte("\x00")}
+	if res, err := db.PlayScript(ctx, "echo", args); err != nil || !reflect.DeepEqual(res, args) {
+		t.Errorf("unexpected result %q %v", res, err)
+	}
+	if _, err := db.PlayScript(ctx, "fail", args); err != ErrScriptFailed {
+		t.Errorf("expecting ErrScriptFailed, got %v", err)
+	}
+	// The connection is still usable after a failure.
+	if len(db.binary.idle) != 1 {
+		t.Errorf("expecting one idle connection, got %d", len(db.binary.idle))
+	}
+}
+
+// Start a server answering every read from the connection with the
+// response, nothing if it's nil.
+func startRawBinary(t testing.TB, response []byte) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 4096)
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						return
+					}
+					if response != nil {
+						conn.Write(response)
+					}
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func TestFakeBinaryErrors(t *testing.T) {
+	ctx := context.Background()
+	s, db := startFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected HTTP request %s", r.URL.Path)
+	})
+	defer s.Close()
+
+	var e *Error
+	l := startRawBinary(t, []byte{binaryError})
+	db.binary = newBinaryPool("tcp", l.Addr().String(), 1, DEFAULT_TIMEOUT)
+	err := db.GetBulkBytes(ctx, map[string][]byte{"a": nil})
+	if !errors.As(err, &e) || e.Class != ClassImplementation || e.Op != "get_bulk" || !errors.Is(err, errBinaryFailed) {
+		t.Errorf("expecting a failure of the server, got %#v", err)
+	}
+	l.Close()
+
+	// One hit of a 4GB key.
+	huge := []byte{binaryGetBulk, 0, 0, 0, 1, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	l = startRawBinary(t, huge)
+	db.binary = newBinaryPool("tcp", l.Addr().String(), 1, DEFAULT_TIMEOUT)
+	err = db.GetBulkBytes(ctx, map[string][]byte{"a": nil})
+	if !errors.As(err, &e) || e.Class != ClassImplementation || !errors.Is(err, errBinaryProtocol) {
+		t.Errorf("expecting a protocol error, got %#v", err)
+	}
+	l.Close()
+
+	l = startRawBinary(t, nil)
+	defer l.Close()
+	db.binary = newBinaryPool("tcp", l.Addr().String(), 1, time.Minute)
+	cancelled, cancel := context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = db.RemoveBulk(cancelled, []string{"a"})
+	if !errors.Is(err, context.Canceled) || time.Since(start) > 10*time.Second {
+		t.Errorf("expecting the request to be cancelled, got %#v", err)
+	}
+	if len(db.binary.idle) != 0 {
+		t.Error("expecting the connection of the cancelled request to be closed")
+	}
+}
+
+func TestFakeErrors(t *testing.T) {
+	ctx := context.Background()
+	s, db := startFakeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			w.WriteHeader(500)
+			w.Write([]byte("boom\n"))
+		case "/rpc/add":
+			writeTSV(w, 400, KV{"ERROR", []byte("DB: 3: invalid operation: bad")})
+		default:
+			writeTSV(w, 503, KV{"ERROR", []byte("timeout")})
+		}
+	})
+
+	var e *Error
+	err := db.Set(ctx, "a", []byte("va"), time.Time{})
+	if !errors.As(err, &e) || e.Code != 500 || e.Class != ClassImplementation || e.Op != "set" || e.Key != "a" {
+		t.Errorf("unexpected error %#v", err)
+	}
+	if msg := err.Error(); msg != `kt: set "a": boom` {
+		t.Errorf("unexpected message %q", msg)
+	}
+	err = db.Add(ctx, "a", []byte("va"), time.Time{})
+	want := &Error{Message: "invalid operation: bad", Code: 400, Class: ClassInvalidArgument, Op: "add", Key: "a"}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("want %#v, got %#v", want, err)
+	}
+	if _, err := db.Count(ctx); !errors.Is(err, ErrTimeout) || err == ErrTimeout {
+		t.Errorf("expecting a timeout of the server, got %v", err)
+	}
+
+	expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = db.GetBytes(expired, "b")
+	if !errors.As(err, &e) || e.Class != ClassTimeout || e.Key != "b" || !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrTimeout) {
+		t.Errorf("expecting a timeout of the context, got %#v", err)
+	}
+
+	s.Close()
+	_, err = db.MatchRegex(ctx, "^a", 10)
+	var operr *net.OpError
+	if !errors.As(err, &e) || e.Class != ClassNetwork || e.Op != "match_regex" || !errors.As(err, &operr) {
+		t.Errorf("expecting a network error, got %#v", err)
+	}
+
+	if msg := ErrNotFound.Error(); strings.HasSuffix(msg, "\n") {
+		t.Errorf("unexpected newline in %q", msg)
+	}
+	if err := fmt.Errorf("lookup: %w", ErrNotFound); !errors.Is(err, ErrNotFound) || errors.Is(err, ErrTimeout) {
+		t.Errorf("expecting the sentinel to match, got %v", err)
+	}
+	if ClassLogicalInconsistency.String() != "logical inconsistency" || Class(42).String() != "Class(42)" {
+		t.Error("unexpected class names")
+	}
+}
@@ -25,6 +25,21 @@ const (
 	opSetBulk      = "SETBULK"
 	opRemoveBulk   = "REMOVEBULK"
 	opMatchPrefix  = "MATCHPREFIX"
+	opAdd          = "ADD"
+	opReplace      = "REPLACE"
+	opAppend       = "APPEND"
+	opIncrement    = "INCREMENT"
+	opIncrementDbl = "INCREMENTDOUBLE"
+	opCAS          = "CAS"
+	opSeize        = "SEIZE"
+	opMatchRegex   = "MATCHREGEX"
+	opMatchSimilar = "MATCHSIMILAR"
+	opClear        = "CLEAR"
+	opSynchronize  = "SYNCHRONIZE"
+	opVacuum       = "VACUUM"
+	opReport       = "REPORT"
+	opPlayScript   = "PLAYSCRIPT"
+	opScan         = "SCAN"
 )
 
 // NewTrackedConn creates a new connection to a Kyoto Tycoon endpoint, and tracks
@@ -68,7 +83,7 @@ func (c *TrackedConn) Remove(ctx context.Context, key string) error {
 		c.opTimer.WithLabelValues(opRemove).Observe(since.Seconds())
 	}()
 
-	return c.kt.remove(ctx, key)
+	return c.kt.Remove(ctx, key)
 }
 
 func (c *TrackedConn) GetBulk(ctx context.Context, keysAndVals map[string]string) error {
@@ -101,14 +116,14 @@ func (c *TrackedConn) GetBytes(ctx context.Context, key string) ([]byte, error)
 	return c.kt.GetBytes(ctx, key)
 }
 
-func (c *TrackedConn) set(ctx context.Context, key string, value []byte) error {
+func (c *TrackedConn) Set(ctx context.Context, key string, value []byte, expire time.Time) error {
 	start := time.Now()
 	defer func() {
 		since := time.Since(start)
 		c.opTimer.WithLabelValues(opSet).Observe(since.Seconds())
 	}()
 
-	return c.kt.set(ctx, key, value)
+	return c.kt.Set(ctx, key, value, expire)
 }
 
 func (c *TrackedConn) GetBulkBytes(ctx context.Context, keys map[string][]byte) error {
@@ -121,14 +136,14 @@ func (c *TrackedConn) GetBulkBytes(ctx context.Context, keys map[string][]byte)
 	return c.kt.GetBulkBytes(ctx, keys)
 }
 
-func (c *TrackedConn) setBulk(ctx context.Context, values map[string]string) (int64, error) {
+func (c *TrackedConn) SetBulk(ctx context.Context, values map[string]string, expire time.Time) (int64, error) {
 	start := time.Now()
 	defer func() {
 		since := time.Since(start)
 		c.opTimer.WithLabelValues(opSetBulk).Observe(since.Seconds())
 	}()
 
-	return c.kt.setBulk(ctx, values)
+	return c.kt.SetBulk(ctx, values, expire)
 }
 
 func (c *TrackedConn) RemoveBulk(ctx context.Context, keys []string) (int64, error) {
@@ -138,7 +153,7 @@ func (c *TrackedConn) RemoveBulk(ctx context.Context, keys []string) (int64, err
 		c.opTimer.WithLabelValues(opRemoveBulk).Observe(since.Seconds())
 	}()
 
-	return c.kt.removeBulk(ctx, keys)
+	return c.kt.RemoveBulk(ctx, keys)
 }
 
 func (c *TrackedConn) MatchPrefix(ctx context.Context, key string, maxrecords int64) ([]string, error) {
@@ -150,3 +165,158 @@ func (c *TrackedConn) MatchPrefix(ctx context.Context, key string, maxrecords in
 
 	return c.kt.MatchPrefix(ctx, key, maxrecords)
 }
+
+func (c *TrackedConn) Add(ctx context.Context, key string, value []byte, expire time.Time) error {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opAdd).Observe(since.Seconds())
+	}()
+
+	return c.kt.Add(ctx, key, value, expire)
+}
+
+func (c *TrackedConn) Replace(ctx context.Context, key string, value []byte, expire time.Time) error {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opReplace).Observe(since.Seconds())
+	}()
+
+	return c.kt.Replace(ctx, key, value, expire)
+}
+
+func (c *TrackedConn) Append(ctx context.Context, key string, value []byte, expire time.Time) error {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opAppend).Observe(since.Seconds())
+	}()
+
+	return c.kt.Append(ctx, key, value, expire)
+}
+
+func (c *TrackedConn) Increment(ctx context.Context, key string, num int64, expire time.Time) (int64, error) {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opIncrement).Observe(since.Seconds())
+	}()
+
+	return c.kt.Increment(ctx, key, num, expire)
+}
+
+func (c *TrackedConn) IncrementDouble(ctx context.Context, key string, num float64, expire time.Time) (float64, error) {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opIncrementDbl).Observe(since.Seconds())
+	}()
+
+	return c.kt.IncrementDouble(ctx, key, num, expire)
+}
+
+func (c *TrackedConn) CAS(ctx context.Context, key string, old, new []byte, expire time.Time) error {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opCAS).Observe(since.Seconds())
+	}()
+
+	return c.kt.CAS(ctx, key, old, new, expire)
+}
+
+func (c *TrackedConn) Seize(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opSeize).Observe(since.Seconds())
+	}()
+
+	return c.kt.Seize(ctx, key)
+}
+
+func (c *TrackedConn) MatchRegex(ctx context.Context, regex string, maxrecords int64) ([]string, error) {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opMatchRegex).Observe(since.Seconds())
+	}()
+
+	return c.kt.MatchRegex(ctx, regex, maxrecords)
+}
+
+func (c *TrackedConn) MatchSimilar(ctx context.Context, origin string, distance int64, utf bool, maxrecords int64) ([]string, error) {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opMatchSimilar).Observe(since.Seconds())
+	}()
+
+	return c.kt.MatchSimilar(ctx, origin, distance, utf, maxrecords)
+}
+
+func (c *TrackedConn) Clear(ctx context.Context) error {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opClear).Observe(since.Seconds())
+	}()
+
+	return c.kt.Clear(ctx)
+}
+
+func (c *TrackedConn) Synchronize(ctx context.Context, hard bool, command string) error {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opSynchronize).Observe(since.Seconds())
+	}()
+
+	return c.kt.Synchronize(ctx, hard, command)
+}
+
+func (c *TrackedConn) Vacuum(ctx context.Context, step int64) error {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opVacuum).Observe(since.Seconds())
+	}()
+
+	return c.kt.Vacuum(ctx, step)
+}
+
+func (c *TrackedConn) Report(ctx context.Context) (map[string]string, error) {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opReport).Observe(since.Seconds())
+	}()
+
+	return c.kt.Report(ctx)
+}
+
+func (c *TrackedConn) PlayScript(ctx context.Context, name string, args map[string][]byte) (map[string][]byte, error) {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opPlayScript).Observe(since.Seconds())
+	}()
+
+	return c.kt.PlayScript(ctx, name, args)
+}
+
+func (c *TrackedConn) Scan(ctx context.Context, prefix string, fn func(key string, value []byte) error) error {
+	start := time.Now()
+	defer func() {
+		since := time.Since(start)
+		c.opTimer.WithLabelValues(opScan).Observe(since.Seconds())
+	}()
+
+	return c.kt.Scan(ctx, prefix, fn)
+}
+
+// NewCursor creates a cursor on the database, its calls aren't tracked.
+func (c *TrackedConn) NewCursor(ctx context.Context) *Cursor {
+	return c.kt.NewCursor(ctx)
+}
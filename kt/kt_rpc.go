@@ -0,0 +1,327 @@
+package kt
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// KT answers with the 450 status code when a procedure couldn't be
+// done because of the state of the database, what it calls a
+// logical inconsistency. The meaning depends on the procedure, these
+// are the errors it's turned into. Replace and Seize return
+// ErrNotFound.
+var (
+	// Add found the record already there.
+	ErrExists = inconsistency("record exists")
+	// Increment or IncrementDouble found a record of a different
+	// numeric type.
+	ErrIncompatible = inconsistency("record is not compatible")
+	// CAS found a different value than expected.
+	ErrCASMismatch = inconsistency("record value mismatch")
+	// The postprocessing command of Synchronize failed.
+	ErrSyncCommand = inconsistency("postprocessing command failed")
+	// The script run by PlayScript failed.
+	ErrScriptFailed = inconsistency("script failed")
+)
+
+func inconsistency(msg string) *Error {
+	return &Error{Message: msg, Code: 450, Class: ClassLogicalInconsistency}
+}
+
+// Call the RPC procedure and return its output records. 450 answers
+// are turned into inconsistent, unless it's nil.
+func (c *Conn) doCall(ctx context.Context, name string, values []KV, inconsistent error) ([]KV, error) {
+	code, m, err := c.doRPC(ctx, "/rpc/"+name, values)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case code == 200:
+		return m, nil
+	case code == 450 && inconsistent != nil:
+		return nil, inconsistent
+	}
+	return nil, makeError(name, string(findRec(values, "key").Value), code, m)
+}
+
+// Append the xt parameter, unless expire is zero.
+func withExpire(values []KV, expire time.Time) []KV {
+	if expire.IsZero() {
+		return values
+	}
+	return append(values, KV{"xt", xtValue(expire)})
+}
+
+// Keys of the records named with the _ prefix.
+func recordKeys(m []KV) []string {
+	res := make([]string, 0, len(m))
+	for _, kv := range m {
+		if len(kv.Key) > 0 && kv.Key[0] == '_' {
+			res = append(res, kv.Key[1:])
+		}
+	}
+	return res
+}
+
+// Store a record with one of the set-like procedures.
+func (c *Conn) store(ctx context.Context, op, name, key string, value []byte, expire time.Time, inconsistent error) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc "+op)
+	defer span.Finish()
+	span.SetTag("key", key)
+
+	values := withExpire([]KV{{"key", []byte(key)}, {"value", value}}, expire)
+	_, err := c.doCall(ctx, name, values, inconsistent)
+	if err != nil {
+		span.SetTag("status", err)
+	}
+	return err
+}
+
+// Add stores the data at key, only if there is no record yet.
+// ErrExists is returned otherwise. The record expires at expire,
+// unless it's zero.
+func (c *Conn) Add(ctx context.Context, key string, value []byte, expire time.Time) error {
+	return c.store(ctx, "Add", "add", key, value, expire, ErrExists)
+}
+
+// Replace stores the data at key, only if there is a record already.
+// ErrNotFound is returned otherwise. The record expires at expire,
+// unless it's zero.
+func (c *Conn) Replace(ctx context.Context, key string, value []byte, expire time.Time) error {
+	return c.store(ctx, "Replace", "replace", key, value, expire, ErrNotFound)
+}
+
+// Append adds the data at the end of the record at key, or stores it
+// if there is no record. The record expires at expire, unless it's
+// zero.
+func (c *Conn) Append(ctx context.Context, key string, value []byte, expire time.Time) error {
+	return c.store(ctx, "Append", "append", key, value, expire, nil)
+}
+
+// Increment adds num to the integer stored at key and returns the
+// result. A missing record counts as zero. ErrIncompatible is
+// returned if the record isn't an integer stored by Increment. The
+// record expires at expire, unless it's zero.
+func (c *Conn) Increment(ctx context.Context, key string, num int64, expire time.Time) (int64, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc Increment")
+	defer span.Finish()
+	span.SetTag("key", key)
+
+	values := withExpire([]KV{
+		{"key", []byte(key)},
+		{"num", []byte(strconv.FormatInt(num, 10))},
+	}, expire)
+	m, err := c.doCall(ctx, "increment", values, ErrIncompatible)
+	if err != nil {
+		span.SetTag("status", err)
+		return 0, err
+	}
+	return strconv.ParseInt(string(findRec(m, "num").Value), 10, 64)
+}
+
+// IncrementDouble adds num to the decimal number stored at key and
+// returns the result, see Increment.
+func (c *Conn) IncrementDouble(ctx context.Context, key string, num float64, expire time.Time) (float64, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc IncrementDouble")
+	defer span.Finish()
+	span.SetTag("key", key)
+
+	values := withExpire([]KV{
+		{"key", []byte(key)},
+		{"num", []byte(strconv.FormatFloat(num, 'g', -1, 64))},
+	}, expire)
+	m, err := c.doCall(ctx, "increment_double", values, ErrIncompatible)
+	if err != nil {
+		span.SetTag("status", err)
+		return 0, err
+	}
+	return strconv.ParseFloat(string(findRec(m, "num").Value), 64)
+}
+
+// CAS replaces the data at key with new, only if it's old. Nil old
+// means there must be no record, nil new removes the record.
+// ErrCASMismatch is returned if the record is different. The record
+// expires at expire, unless it's zero.
+func (c *Conn) CAS(ctx context.Context, key string, old, new []byte, expire time.Time) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc CAS")
+	defer span.Finish()
+	span.SetTag("key", key)
+
+	values := []KV{{"key", []byte(key)}}
+	if old != nil {
+		values = append(values, KV{"oval", old})
+	}
+	if new != nil {
+		values = append(values, KV{"nval", new})
+	}
+	_, err := c.doCall(ctx, "cas", withExpire(values, expire), ErrCASMismatch)
+	if err != nil {
+		span.SetTag("status", err)
+	}
+	return err
+}
+
+// Seize retrieves the data stored at key and removes the record.
+// ErrNotFound is returned if no such data exists.
+func (c *Conn) Seize(ctx context.Context, key string) ([]byte, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc Seize")
+	defer span.Finish()
+	span.SetTag("key", key)
+
+	m, err := c.doCall(ctx, "seize", []KV{{"key", []byte(key)}}, ErrNotFound)
+	if err != nil {
+		span.SetTag("status", err)
+		return nil, err
+	}
+	return findRec(m, "value").Value, nil
+}
+
+// MatchRegex returns at most maxrecords keys matching the regular
+// expression, all of them if maxrecords is negative.
+func (c *Conn) MatchRegex(ctx context.Context, regex string, maxrecords int64) ([]string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc MatchRegex")
+	defer span.Finish()
+	span.SetTag("regex", regex)
+	span.SetTag("limit", maxrecords)
+
+	m, err := c.doCall(ctx, "match_regex", []KV{
+		{"regex", []byte(regex)},
+		{"max", []byte(strconv.FormatInt(maxrecords, 10))},
+	}, nil)
+	if err != nil {
+		span.SetTag("status", err)
+		return nil, err
+	}
+	return recordKeys(m), nil
+}
+
+// MatchSimilar returns at most maxrecords keys within the Levenshtein
+// distance from origin, the closest first. With utf set the distance
+// is counted in UTF-8 characters rather than in bytes.
+func (c *Conn) MatchSimilar(ctx context.Context, origin string, distance int64, utf bool, maxrecords int64) ([]string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc MatchSimilar")
+	defer span.Finish()
+	span.SetTag("origin", origin)
+	span.SetTag("limit", maxrecords)
+
+	values := []KV{
+		{"origin", []byte(origin)},
+		{"range", []byte(strconv.FormatInt(distance, 10))},
+		{"max", []byte(strconv.FormatInt(maxrecords, 10))},
+	}
+	if utf {
+		values = append(values, KV{"utf", []byte("true")})
+	}
+	m, err := c.doCall(ctx, "match_similar", values, nil)
+	if err != nil {
+		span.SetTag("status", err)
+		return nil, err
+	}
+	return recordKeys(m), nil
+}
+
+// Clear removes all the records in the database.
+func (c *Conn) Clear(ctx context.Context) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc Clear")
+	defer span.Finish()
+
+	_, err := c.doCall(ctx, "clear", nil, nil)
+	if err != nil {
+		span.SetTag("status", err)
+	}
+	return err
+}
+
+// Synchronize writes the database to the file system, with hard set
+// to the device as well. The command, if not empty, is run by the
+// server afterwards, ErrSyncCommand is returned if it fails.
+func (c *Conn) Synchronize(ctx context.Context, hard bool, command string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc Synchronize")
+	defer span.Finish()
+
+	var values []KV
+	if hard {
+		values = append(values, KV{"hard", []byte("true")})
+	}
+	if command != "" {
+		values = append(values, KV{"command", []byte(command)})
+	}
+	_, err := c.doCall(ctx, "synchronize", values, ErrSyncCommand)
+	if err != nil {
+		span.SetTag("status", err)
+	}
+	return err
+}
+
+// Vacuum frees the unused space of the database, step records at a
+// time, or all at once if step is zero.
+func (c *Conn) Vacuum(ctx context.Context, step int64) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc Vacuum")
+	defer span.Finish()
+
+	var values []KV
+	if step > 0 {
+		values = append(values, KV{"step", []byte(strconv.FormatInt(step, 10))})
+	}
+	_, err := c.doCall(ctx, "vacuum", values, nil)
+	if err != nil {
+		span.SetTag("status", err)
+	}
+	return err
+}
+
+// Report returns the statistics of the server, like its version,
+// the number of connections and of the records.
+func (c *Conn) Report(ctx context.Context) (map[string]string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc Report")
+	defer span.Finish()
+
+	m, err := c.doCall(ctx, "report", nil, nil)
+	if err != nil {
+		span.SetTag("status", err)
+		return nil, err
+	}
+	res := make(map[string]string, len(m))
+	for _, kv := range m {
+		res[kv.Key] = string(kv.Value)
+	}
+	return res, nil
+}
+
+// PlayScript calls the procedure of the server side script with the
+// arguments and returns its output. ErrScriptFailed is returned if
+// the procedure fails.
+func (c *Conn) PlayScript(ctx context.Context, name string, args map[string][]byte) (map[string][]byte, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ktrpc PlayScript")
+	defer span.Finish()
+	span.SetTag("name", name)
+
+	if c.binary != nil {
+		res, err := c.binaryPlayScript(ctx, name, args)
+		if err != nil {
+			span.SetTag("status", err)
+		}
+		return res, err
+	}
+
+	values := make([]KV, 0, len(args)+1)
+	values = append(values, KV{"name", []byte(name)})
+	for k, v := range args {
+		values = append(values, KV{"_" + k, v})
+	}
+	m, err := c.doCall(ctx, "play_script", values, ErrScriptFailed)
+	if err != nil {
+		span.SetTag("status", err)
+		return nil, err
+	}
+	res := make(map[string][]byte, len(m))
+	for _, kv := range m {
+		if len(kv.Key) > 0 && kv.Key[0] == '_' {
+			res[kv.Key[1:]] = kv.Value
+		}
+	}
+	return res, nil
+}
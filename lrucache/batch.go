@@ -0,0 +1,176 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"time"
+)
+
+// Entry is an item passed to SetMulti.
+type Entry[K comparable, V any] struct {
+	Key    K
+	Value  V
+	Expire time.Time
+}
+
+// Look up the keys, possibly stale, and add the found ones to out.
+// Must be called with the lock held.
+func (b *TypedLRUCache[K, V]) getMultiLocked(keys []K, out map[K]V) {
+	for _, key := range keys {
+		e := b.table[key]
+		if e == nil {
+			b.missEntry(key)
+			continue
+		}
+		b.stats.hits.Add(1)
+		b.touchEntry(e, time.Time{})
+		out[key] = e.value
+	}
+}
+
+// GetMulti gets the keys from the cache, possibly stale, taking the
+// lock once. The keys are looked up in order, so the LRU scores are
+// the same as after calling Get for every key. Returns the keys that
+// were found with their values.
+func (b *TypedLRUCache[K, V]) GetMulti(keys []K) map[K]V {
+	out := make(map[K]V, len(keys))
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	b.getMultiLocked(keys, out)
+	return out
+}
+
+// SetMulti adds the items to the cache, overwriting the existing ones,
+// taking the lock once. Items are added in order, a later item for
+// the same key wins.
+func (b *TypedLRUCache[K, V]) SetMulti(entries []Entry[K, V]) {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	b.setMultiLocked(entries, &evs)
+}
+
+// Same as SetMulti, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) setMultiLocked(entries []Entry[K, V], evs *[]evicted[K, V]) {
+	for i := range entries {
+		e := &entries[i]
+		b.set(e.Key, e.Value, e.Expire, nil, time.Time{}, evs)
+	}
+}
+
+// DelMulti removes the keys from the cache, taking the lock once.
+// Returns the number of removed keys.
+func (b *TypedLRUCache[K, V]) DelMulti(keys []K) int {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.delMultiLocked(keys, &evs)
+}
+
+// Same as DelMulti, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) delMultiLocked(keys []K, evs *[]evicted[K, V]) int {
+	n := 0
+	for _, key := range keys {
+		if e := b.table[key]; e != nil {
+			b.evictEntry(e, EvictDeleted, evs)
+			n += 1
+		}
+	}
+	return n
+}
+
+// Split the items by shard, keeping their order. Returns the
+// positions of the items for every shard.
+func (s *multiShards[K, V]) groupByShard(n int, key func(i int) K) [][]int {
+	groups := make([][]int, len(s.cache))
+	for i := 0; i < n; i++ {
+		b := s.bucketNo(key(i))
+		groups[b] = append(groups[b], i)
+	}
+	return groups
+}
+
+// GetMulti gets the keys from the cache, possibly stale, locking
+// every shard once. Returns the keys that were found with their
+// values.
+func (m *TypedMultiLRUCache[K, V]) GetMulti(keys []K) map[K]V {
+	out := make(map[K]V, len(keys))
+	m.do(func(ms *multiShards[K, V]) bool {
+		var shardKeys []K
+		for b, group := range ms.groupByShard(len(keys), func(i int) K { return keys[i] }) {
+			if len(group) == 0 {
+				continue
+			}
+			shardKeys = shardKeys[:0]
+			for _, i := range group {
+				shardKeys = append(shardKeys, keys[i])
+			}
+			c := ms.cache[b]
+			ok := c.withLock(func(evs *[]evicted[K, V]) {
+				c.getMultiLocked(shardKeys, out)
+			})
+			if !ok {
+				return false
+			}
+		}
+		return true
+	})
+	return out
+}
+
+// SetMulti adds the items to the cache, locking every shard once.
+// Items for the same key are added in order, the last one wins.
+func (m *TypedMultiLRUCache[K, V]) SetMulti(entries []Entry[K, V]) {
+	m.do(func(ms *multiShards[K, V]) bool {
+		var shardEntries []Entry[K, V]
+		for b, group := range ms.groupByShard(len(entries), func(i int) K { return entries[i].Key }) {
+			if len(group) == 0 {
+				continue
+			}
+			shardEntries = shardEntries[:0]
+			for _, i := range group {
+				shardEntries = append(shardEntries, entries[i])
+			}
+			c := ms.cache[b]
+			ok := c.withLock(func(evs *[]evicted[K, V]) {
+				c.setMultiLocked(shardEntries, evs)
+			})
+			if !ok {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// DelMulti removes the keys from the cache, locking every shard once.
+// Returns the number of removed keys.
+func (m *TypedMultiLRUCache[K, V]) DelMulti(keys []K) int {
+	n := 0
+	m.do(func(ms *multiShards[K, V]) bool {
+		var shardKeys []K
+		for b, group := range ms.groupByShard(len(keys), func(i int) K { return keys[i] }) {
+			if len(group) == 0 {
+				continue
+			}
+			shardKeys = shardKeys[:0]
+			for _, i := range group {
+				shardKeys = append(shardKeys, keys[i])
+			}
+			c := ms.cache[b]
+			ok := c.withLock(func(evs *[]evicted[K, V]) {
+				n += c.delMultiLocked(shardKeys, evs)
+			})
+			if !ok {
+				return false
+			}
+		}
+		return true
+	})
+	return n
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	t.Parallel()
+	b := NewTypedLRUCache[string, int](3)
+
+	b.SetMulti([]Entry[string, int]{
+		{Key: "a", Value: 1},
+		{Key: "b", Value: 2},
+		{Key: "a", Value: 3},
+		{Key: "c", Value: 4, Expire: time.Now().Add(-time.Second)},
+	})
+	if b.Len() != 3 {
+		t.Error("expecting three entries")
+	}
+
+	got := b.GetMulti([]string{"b", "a", "x", "c"})
+	if len(got) != 3 || got["a"] != 3 || got["b"] != 2 || got["c"] != 4 {
+		t.Errorf("unexpected values %v", got)
+	}
+	if s := b.Stats(); s.Hits != 3 || s.Misses != 1 {
+		t.Error("expecting lookups to be counted")
+	}
+
+	// Lookups happen in order, so B was used before A and C.
+	b.Set("d", 5, time.Time{})
+	b.Set("e", 6, time.Time{})
+	if _, ok := b.GetQuiet("b"); ok {
+		t.Error("expecting B to be evicted")
+	}
+
+	if n := b.DelMulti([]string{"a", "b", "d", "d"}); n != 2 || b.Len() != 1 {
+		t.Error("expecting A and D to be deleted")
+	}
+}
+
+func TestMultiLRUBatch(t *testing.T) {
+	t.Parallel()
+	m := NewTypedMultiLRUCache[string, int](4, 100)
+
+	var entries []Entry[string, int]
+	var keys []string
+	for i := 0; i < 50; i++ {
+		k := strconv.Itoa(i)
+		entries = append(entries, Entry[string, int]{Key: k, Value: i})
+		keys = append(keys, k)
+	}
+	entries = append(entries, Entry[string, int]{Key: "0", Value: 100})
+	m.SetMulti(entries)
+	if m.Len() != 50 {
+		t.Error("expecting all entries to be added")
+	}
+
+	got := m.GetMulti(append(keys, "missing"))
+	if len(got) != 50 || got["0"] != 100 || got["49"] != 49 {
+		t.Error("expecting all entries to be found")
+	}
+	if s := m.Stats(); s.Hits != 50 || s.Misses != 1 {
+		t.Error("expecting lookups to be counted")
+	}
+
+	if n := m.DelMulti(keys[:10]); n != 10 || m.Len() != 40 {
+		t.Error("expecting entries to be deleted")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/golibs/lrucache"
+)
+
+var concurrencyCaches = []struct {
+	name string
+	make makeCache
+}{
+	{"LRUCache", makeLRUCache},
+	{"MultiLRU-16", func(capacity uint64) lrucache.Cache {
+		return lrucache.NewMultiLRUCache(16, uint(capacity)/16)
+	}},
+	{"ClockCache", func(capacity uint64) lrucache.Cache {
+		return lrucache.NewClockCache(uint(capacity))
+	}},
+}
+
+// Run ops operations split between the goroutines, one in every
+// setEvery of them is a Set, the rest are Gets. Returns the time per
+// operation.
+func runConcurrent(c lrucache.Cache, keys []string, goroutines, ops, setEvery int) time.Duration {
+	var wg sync.WaitGroup
+	t0 := time.Now()
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < ops; i += goroutines {
+				k := keys[i%len(keys)]
+				if setEvery > 0 && i%setEvery == 0 {
+					c.Set(k, k, time.Time{})
+				} else {
+					c.Get(k)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	return time.Since(t0) / time.Duration(ops)
+}
+
+// Time per operation of read heavy workloads with 1 to 64
+// goroutines sharing a cache.
+func concurrency() {
+	const (
+		capacity = 64 * 1024
+		ops      = 4000000
+	)
+	keys := skewedWorkload(1000000, capacity*4)
+
+	for _, setEvery := range []int{0, 10} {
+		if setEvery == 0 {
+			fmt.Printf("[*] Concurrent Get, Capacity=%v\n", capacity)
+		} else {
+			fmt.Printf("[*] Concurrent Get with 1 Set in %v, Capacity=%v\n", setEvery, capacity)
+		}
+		fmt.Printf("goroutines")
+		for _, cc := range concurrencyCaches {
+			fmt.Printf("\t%-10s", cc.name)
+		}
+		fmt.Printf("\n")
+
+		for goroutines := 1; goroutines <= 64; goroutines *= 2 {
+			fmt.Printf("%-10s", strconv.Itoa(goroutines))
+			for _, cc := range concurrencyCaches {
+				c := cc.make(capacity)
+				// Warm up, so that Gets mostly hit.
+				for _, k := range keys {
+					c.Set(k, k, time.Time{})
+				}
+				fmt.Printf("\t%-10v", runConcurrent(c, keys, goroutines, ops, setEvery))
+			}
+			fmt.Printf("\n")
+		}
+		fmt.Printf("\n")
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/cloudflare/golibs/lrucache"
+)
+
+var policies = []lrucache.Policy{
+	lrucache.PolicyLRU,
+	lrucache.PolicySLRU,
+	lrucache.Policy2Q,
+	lrucache.PolicyTinyLFU,
+}
+
+// Zipf distributed keys, every now and then interrupted by a scan
+// over keys never seen before, like a crawler walking a long tail.
+func skewedWorkload(n int, keySpace uint64) []string {
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, 1.1, 1, keySpace-1)
+
+	keys := make([]string, 0, n)
+	scan := 0
+	for len(keys) < n {
+		if len(keys)%100000 == 50000 {
+			for i := 0; i < 20000; i++ {
+				keys = append(keys, "scan"+strconv.Itoa(scan))
+				scan += 1
+			}
+		}
+		keys = append(keys, strconv.FormatUint(z.Uint64(), 10))
+	}
+	return keys[:n]
+}
+
+func hitRatio(c lrucache.Cache, keys []string) float64 {
+	hits := 0
+	for _, k := range keys {
+		if _, ok := c.Get(k); ok {
+			hits += 1
+		} else {
+			c.Set(k, k, time.Time{})
+		}
+	}
+	return float64(hits) / float64(len(keys))
+}
+
+func hitRatios() {
+	keySpace := uint64(1000000)
+	keys := skewedWorkload(2000000, keySpace)
+
+	fmt.Printf("[*] Hit ratio Requests=%v KeySpace=%v Zipf s=1.1 with scans\n", len(keys), keySpace)
+	fmt.Printf("capacity")
+	for _, p := range policies {
+		fmt.Printf("\t%v", p)
+	}
+	fmt.Printf("\n")
+
+	for _, capacity := range []uint{1024, 8192, 65536} {
+		fmt.Printf("%v", capacity)
+		for _, p := range policies {
+			c := &lrucache.LRUCache{Policy: p}
+			c.Init(capacity)
+			fmt.Printf("\t%.2f%%", 100*hitRatio(c, keys))
+		}
+		fmt.Printf("\n")
+	}
+	fmt.Printf("\n")
+}
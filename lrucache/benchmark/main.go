@@ -1,6 +1,8 @@
 // Copyright (c) 2013 CloudFlare, Inc.
 
-// Microbenchmarks for LRUCache, MultiLRUCache versus vitess/cache.
+// Microbenchmarks for LRUCache, MultiLRUCache versus vitess/cache,
+// hit ratios of the lrucache eviction policies and scalability of
+// the caches with concurrent goroutines.
 package main
 
 import (
@@ -93,4 +95,7 @@ func main() {
 		}
 		fmt.Printf("\n\n")
 	}
+
+	hitRatios()
+	concurrency()
 }
@@ -0,0 +1,834 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"bytes"
+	"hash/maphash"
+	"math"
+	"sync"
+	"time"
+)
+
+// Keys and values of ByteCache are stored in blocks of this size. An
+// item takes len(key)+len(value) bytes rounded up to whole blocks.
+const byteBlockSize = 64
+
+// Entry of ByteCache. Holds no pointers, entries refer to each other
+// and to their data by numbers, so the garbage collector never has to
+// scan them.
+type byteEntry struct {
+	hash     uint64
+	expire   int64 // UnixNano, 0 for the zero time
+	block    int32 // first block of the key followed by the value, -1 if none
+	keyLen   uint32
+	valueLen uint32
+	prev     int32 // towards the most recently used entry, -1 if none
+	next     int32 // towards the least recently used entry, -1 if none
+	index    int32 // in expiryQueue, -1 if not there
+}
+
+// ByteCache is an LRU cache of byte slice keys and values. It has the
+// same LRU and expiry semantics as LRUCache, but instead of
+// interface{} values keeps copies of the items in an arena allocated
+// on Init, indexed by an open addressing hash table. None of its
+// memory holds pointers, so even a cache of millions of items costs
+// nothing to the garbage collector. The cache is bounded both by the
+// number of items and by the size of the arena. Never dereference it
+// or copy it by value. Always use it through a pointer.
+type ByteCache struct {
+	lock        sync.Mutex
+	seed        maphash.Seed
+	entries     []byteEntry // allocated on Init
+	free        []int32     // unused entries
+	table       []int32     // entry numbers, -1 if the slot is empty
+	shift       uint        // turns a hash into a slot of the table
+	head, tail  int32       // most and least recently used entry, -1 if none
+	used        int
+	data        []byte  // the arena, split into blocks
+	nextBlock   []int32 // next block of an item or of the free blocks, -1 if none
+	freeBlock   int32
+	freeBlocks  int
+	expiryQueue []int32 // heap of the entries with expiry set
+	stats       stats
+
+	ExpireGracePeriod time.Duration // time after an expired entry is purged from cache (unless pushed out of LRU)
+
+	// Source of the current time, time.Now if nil. Must be set
+	// before the cache is used.
+	Clock Clock
+}
+
+// Using this constructor is almost always wrong. Use NewByteCache instead.
+func (c *ByteCache) Init(capacity, size uint) {
+	blocks := (size + byteBlockSize - 1) / byteBlockSize
+	if capacity > math.MaxInt32/2 || blocks > math.MaxInt32 {
+		panic("lrucache: ByteCache too large")
+	}
+
+	c.seed = maphash.MakeSeed()
+	c.entries = make([]byteEntry, capacity)
+	c.free = make([]int32, capacity)
+	for i := range c.entries {
+		c.entries[i] = byteEntry{block: -1, prev: -1, next: -1, index: -1}
+		c.free[len(c.free)-1-i] = int32(i)
+	}
+
+	// Keep the table at most half full.
+	slots := uint(1)
+	c.shift = 64
+	for slots < 2*capacity {
+		slots <<= 1
+		c.shift -= 1
+	}
+	c.table = make([]int32, slots)
+	for i := range c.table {
+		c.table[i] = -1
+	}
+	c.head, c.tail = -1, -1
+	c.used = 0
+
+	c.data = make([]byte, blocks*byteBlockSize)
+	c.nextBlock = make([]int32, blocks)
+	for i := range c.nextBlock {
+		c.nextBlock[i] = int32(i) + 1
+	}
+	c.freeBlock = -1
+	if blocks > 0 {
+		c.nextBlock[blocks-1] = -1
+		c.freeBlock = 0
+	}
+	c.freeBlocks = int(blocks)
+	c.expiryQueue = make([]int32, 0, capacity)
+}
+
+// NewByteCache creates a cache of at most capacity items, taking at
+// most size bytes.
+func NewByteCache(capacity, size uint) *ByteCache {
+	c := &ByteCache{}
+	c.Init(capacity, size)
+	return c
+}
+
+// Take the cache lock, counting the times it was held by someone
+// else.
+func (c *ByteCache) acquireLock() {
+	if !c.lock.TryLock() {
+		c.stats.contended.Add(1)
+		c.lock.Lock()
+	}
+}
+
+func toNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+func blocksFor(size int) int {
+	return (size + byteBlockSize - 1) / byteBlockSize
+}
+
+func (c *ByteCache) block(b int32) []byte {
+	return c.data[int(b)*byteBlockSize : (int(b)+1)*byteBlockSize]
+}
+
+// Slot of the table where the search for a hash starts.
+func (c *ByteCache) slot(h uint64) int {
+	return int(h >> c.shift)
+}
+
+func (c *ByteCache) keyEqual(e *byteEntry, key []byte) bool {
+	if int(e.keyLen) != len(key) {
+		return false
+	}
+	for b := e.block; len(key) > 0; b = c.nextBlock[b] {
+		p := c.block(b)
+		if len(p) > len(key) {
+			p = p[:len(key)]
+		}
+		if !bytes.Equal(p, key[:len(p)]) {
+			return false
+		}
+		key = key[len(p):]
+	}
+	return true
+}
+
+// Append n bytes of the item data of an entry, starting at off, to
+// dst.
+func (c *ByteCache) appendData(dst []byte, e *byteEntry, off, n int) []byte {
+	if n == 0 {
+		return dst
+	}
+	b := e.block
+	for ; off >= byteBlockSize; off -= byteBlockSize {
+		b = c.nextBlock[b]
+	}
+	for ; n > 0; b = c.nextBlock[b] {
+		p := c.block(b)[off:]
+		if len(p) > n {
+			p = p[:n]
+		}
+		dst = append(dst, p...)
+		n -= len(p)
+		off = 0
+	}
+	return dst
+}
+
+func (c *ByteCache) value(e *byteEntry) []byte {
+	return c.appendData(make([]byte, 0, e.valueLen), e, int(e.keyLen), int(e.valueLen))
+}
+
+// Copy the key and value to free blocks. Returns the first block.
+// There must be enough of them.
+func (c *ByteCache) storeData(key, value []byte) int32 {
+	n := blocksFor(len(key) + len(value))
+	if n == 0 {
+		return -1
+	}
+	first, last := c.freeBlock, c.freeBlock
+	b := c.freeBlock
+	var p []byte
+	for _, src := range [2][]byte{key, value} {
+		for len(src) > 0 {
+			if len(p) == 0 {
+				p = c.block(b)
+				last = b
+				b = c.nextBlock[b]
+			}
+			k := copy(p, src)
+			p, src = p[k:], src[k:]
+		}
+	}
+	c.nextBlock[last] = -1
+	c.freeBlock = b
+	c.freeBlocks -= n
+	return first
+}
+
+// Return the blocks of an entry to the free ones.
+func (c *ByteCache) freeData(e *byteEntry) {
+	if e.block < 0 {
+		return
+	}
+	last := e.block
+	for c.nextBlock[last] >= 0 {
+		last = c.nextBlock[last]
+	}
+	c.nextBlock[last] = c.freeBlock
+	c.freeBlock = e.block
+	c.freeBlocks += blocksFor(int(e.keyLen) + int(e.valueLen))
+}
+
+// Find the entry of a key, -1 if it's not there. Must be called with
+// the lock held.
+func (c *ByteCache) lookup(key []byte, h uint64) int32 {
+	mask := len(c.table) - 1
+	for i := c.slot(h); ; i = (i + 1) & mask {
+		n := c.table[i]
+		if n < 0 {
+			return -1
+		}
+		if e := &c.entries[n]; e.hash == h && c.keyEqual(e, key) {
+			return n
+		}
+	}
+}
+
+func (c *ByteCache) tableInsert(n int32) {
+	mask := len(c.table) - 1
+	i := c.slot(c.entries[n].hash)
+	for c.table[i] >= 0 {
+		i = (i + 1) & mask
+	}
+	c.table[i] = n
+}
+
+// Remove an entry from the table, shifting back the entries that
+// would become unreachable, so that the table doesn't need
+// tombstones.
+func (c *ByteCache) tableDelete(n int32) {
+	mask := len(c.table) - 1
+	i := c.slot(c.entries[n].hash)
+	for c.table[i] != n {
+		i = (i + 1) & mask
+	}
+	c.table[i] = -1
+	for j := (i + 1) & mask; c.table[j] >= 0; j = (j + 1) & mask {
+		m := c.table[j]
+		k := c.slot(c.entries[m].hash)
+		// Leave the entry if its search starts cyclically in
+		// (i, j].
+		if i <= j && i < k && k <= j || i > j && (i < k || k <= j) {
+			continue
+		}
+		c.table[i] = m
+		c.table[j] = -1
+		i = j
+	}
+}
+
+func (c *ByteCache) pushFront(n int32) {
+	e := &c.entries[n]
+	e.prev, e.next = -1, c.head
+	if c.head >= 0 {
+		c.entries[c.head].prev = n
+	} else {
+		c.tail = n
+	}
+	c.head = n
+}
+
+func (c *ByteCache) unlink(n int32) {
+	e := &c.entries[n]
+	if e.prev >= 0 {
+		c.entries[e.prev].next = e.next
+	} else {
+		c.head = e.next
+	}
+	if e.next >= 0 {
+		c.entries[e.next].prev = e.prev
+	} else {
+		c.tail = e.prev
+	}
+	e.prev, e.next = -1, -1
+}
+
+func (c *ByteCache) touchEntry(n int32) {
+	if c.head != n {
+		c.unlink(n)
+		c.pushFront(n)
+	}
+}
+
+// The expiry queue is a binary heap of entry numbers, same as
+// container/heap but without boxing them.
+func (c *ByteCache) queueLess(i, j int) bool {
+	return c.entries[c.expiryQueue[i]].expire < c.entries[c.expiryQueue[j]].expire
+}
+
+func (c *ByteCache) queueSwap(i, j int) {
+	q := c.expiryQueue
+	q[i], q[j] = q[j], q[i]
+	c.entries[q[i]].index = int32(i)
+	c.entries[q[j]].index = int32(j)
+}
+
+func (c *ByteCache) queueUp(j int) {
+	for j > 0 {
+		i := (j - 1) / 2
+		if !c.queueLess(j, i) {
+			break
+		}
+		c.queueSwap(i, j)
+		j = i
+	}
+}
+
+func (c *ByteCache) queueDown(i int) bool {
+	i0 := i
+	n := len(c.expiryQueue)
+	for {
+		j := 2*i + 1
+		if j >= n {
+			break
+		}
+		if j2 := j + 1; j2 < n && c.queueLess(j2, j) {
+			j = j2
+		}
+		if !c.queueLess(j, i) {
+			break
+		}
+		c.queueSwap(i, j)
+		i = j
+	}
+	return i > i0
+}
+
+func (c *ByteCache) queuePush(n int32) {
+	c.entries[n].index = int32(len(c.expiryQueue))
+	c.expiryQueue = append(c.expiryQueue, n)
+	c.queueUp(len(c.expiryQueue) - 1)
+}
+
+func (c *ByteCache) queueRemove(n int32) {
+	i := int(c.entries[n].index)
+	last := len(c.expiryQueue) - 1
+	if i != last {
+		c.queueSwap(i, last)
+	}
+	c.expiryQueue = c.expiryQueue[:last]
+	if i != last && !c.queueDown(i) {
+		c.queueUp(i)
+	}
+	c.entries[n].index = -1
+}
+
+// Give me the entry with lowest expiry field if it's before now, -1
+// otherwise.
+func (c *ByteCache) expiredEntry(now time.Time) int32 {
+	if len(c.expiryQueue) == 0 {
+		return -1
+	}
+	if now.IsZero() {
+		// Fill it only when actually used.
+		now = c.now()
+	}
+	if n := c.expiryQueue[0]; c.entries[n].expire < now.UnixNano() {
+		return n
+	}
+	return -1
+}
+
+// Free an entry and its blocks. Must be called with the lock held.
+func (c *ByteCache) removeEntry(n int32, reason EvictReason) {
+	c.stats.countEviction(reason)
+	e := &c.entries[n]
+	c.tableDelete(n)
+	c.unlink(n)
+	if e.index >= 0 {
+		c.queueRemove(n)
+	}
+	c.freeData(e)
+	*e = byteEntry{block: -1, prev: -1, next: -1, index: -1}
+	c.free = append(c.free, n)
+	c.used -= 1
+}
+
+// Evict entries until there is a free one and need free blocks,
+// expired entries first. Must be called with the lock held.
+func (c *ByteCache) makeRoom(need int, now time.Time) {
+	if now.IsZero() && len(c.expiryQueue) > 0 {
+		now = c.now()
+	}
+	for len(c.free) == 0 || c.freeBlocks < need {
+		if n := c.expiredEntry(now); n >= 0 {
+			c.removeEntry(n, EvictExpired)
+		} else {
+			c.removeEntry(c.tail, EvictCapacity)
+		}
+	}
+}
+
+// SetNow adds a copy of the item to the cache overwriting existing
+// one if it exists. Allows specifing current time required to expire
+// an item when no more space is left. O(log(n)) if expiry is set,
+// O(1) when clear, plus the number of blocks the item takes. An item
+// larger than the arena is not added, but still replaces the
+// existing one.
+func (c *ByteCache) SetNow(key, value []byte, expire time.Time, now time.Time) {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	h := maphash.Bytes(c.seed, key)
+	if n := c.lookup(key, h); n >= 0 {
+		c.removeEntry(n, EvictOverwritten)
+	}
+
+	need := blocksFor(len(key) + len(value))
+	if len(c.entries) == 0 || need > len(c.nextBlock) ||
+		uint64(len(key)) > math.MaxUint32 || uint64(len(value)) > math.MaxUint32 {
+		return
+	}
+	c.makeRoom(need, now)
+
+	n := c.free[len(c.free)-1]
+	c.free = c.free[:len(c.free)-1]
+	e := &c.entries[n]
+	e.hash = h
+	e.expire = toNano(expire)
+	e.keyLen = uint32(len(key))
+	e.valueLen = uint32(len(value))
+	e.block = c.storeData(key, value)
+	c.tableInsert(n)
+	c.pushFront(n)
+	if e.expire != 0 {
+		c.queuePush(n)
+	}
+	c.used += 1
+}
+
+// Set adds a copy of the item to the cache overwriting existing one
+// if it exists. O(log(n)) if expiry is set, O(1) when clear.
+func (c *ByteCache) Set(key, value []byte, expire time.Time) {
+	c.SetNow(key, value, expire, time.Time{})
+}
+
+// Get a copy of the value of a key from the cache, possibly stale.
+// Update its LRU score. O(1)
+func (c *ByteCache) Get(key []byte) (value []byte, ok bool) {
+	value, _, ok = c.GetWithExpire(key)
+	return value, ok
+}
+
+// GetQuiet gets a copy of the value of a key from the cache, possibly
+// stale. Don't modify its LRU score. O(1)
+func (c *ByteCache) GetQuiet(key []byte) (value []byte, ok bool) {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	n := c.lookup(key, maphash.Bytes(c.seed, key))
+	if n < 0 {
+		c.stats.misses.Add(1)
+		return nil, false
+	}
+	c.stats.hits.Add(1)
+	return c.value(&c.entries[n]), true
+}
+
+// GetWithExpire gets a copy of the value of a key from the cache,
+// possibly stale, together with its expiry time. Update its LRU
+// score. O(1)
+func (c *ByteCache) GetWithExpire(key []byte) (value []byte, expire time.Time, ok bool) {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	n := c.lookup(key, maphash.Bytes(c.seed, key))
+	if n < 0 {
+		c.stats.misses.Add(1)
+		return nil, expire, false
+	}
+	c.stats.hits.Add(1)
+	c.touchEntry(n)
+	e := &c.entries[n]
+	return c.value(e), fromNano(e.expire), true
+}
+
+// GetNotStale gets a copy of the value of a key from the cache, make
+// sure it's not stale. Update its LRU score. O(log(n)) if the item is
+// expired.
+func (c *ByteCache) GetNotStale(key []byte) (value []byte, ok bool) {
+	return c.GetNotStaleNow(key, c.now())
+}
+
+// GetNotStaleNow gets a copy of the value of a key from the cache,
+// make sure it's not stale. Update its LRU score. O(log(n)) if the
+// item is expired.
+func (c *ByteCache) GetNotStaleNow(key []byte, now time.Time) (value []byte, ok bool) {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	n := c.lookup(key, maphash.Bytes(c.seed, key))
+	if n < 0 {
+		c.stats.misses.Add(1)
+		return nil, false
+	}
+
+	e := &c.entries[n]
+	if expire := fromNano(e.expire); hasExpired(expire, now) {
+		c.stats.misses.Add(1)
+		// Remove entries expired for more than a graceful period
+		if c.ExpireGracePeriod == 0 || now.Sub(expire) > c.ExpireGracePeriod {
+			c.removeEntry(n, EvictExpired)
+		}
+		return nil, false
+	}
+
+	c.stats.hits.Add(1)
+	c.touchEntry(n)
+	return c.value(e), true
+}
+
+// GetStale gets a copy of the value of a key from the cache, possibly
+// stale. Update its LRU score. O(1) always.
+func (c *ByteCache) GetStale(key []byte) (value []byte, ok, expired bool) {
+	return c.GetStaleNow(key, c.now())
+}
+
+// GetStaleNow gets a copy of the value of a key from the cache,
+// possibly stale. Update its LRU score. O(1) always.
+func (c *ByteCache) GetStaleNow(key []byte, now time.Time) (value []byte, ok, expired bool) {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	n := c.lookup(key, maphash.Bytes(c.seed, key))
+	if n < 0 {
+		c.stats.misses.Add(1)
+		return nil, false, false
+	}
+
+	e := &c.entries[n]
+	expired = hasExpired(fromNano(e.expire), now)
+	if expired {
+		c.stats.staleHits.Add(1)
+	} else {
+		c.stats.hits.Add(1)
+	}
+	c.touchEntry(n)
+	return c.value(e), true, expired
+}
+
+// Del gets and remove a key from the cache. O(log(n)) if the item is
+// using expiry, O(1) otherwise.
+func (c *ByteCache) Del(key []byte) (value []byte, ok bool) {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	n := c.lookup(key, maphash.Bytes(c.seed, key))
+	if n < 0 {
+		return nil, false
+	}
+	value = c.value(&c.entries[n])
+	c.removeEntry(n, EvictDeleted)
+	return value, true
+}
+
+// DelFunc removes all the entries for which match returns true and
+// returns their number. The key and value passed to match are only
+// valid until it returns. Match is run with the lock held and must
+// not use the cache. O(n)
+func (c *ByteCache) DelFunc(match func(key, value []byte) bool) int {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	var buf []byte
+	var i int
+	for n := c.tail; n >= 0; {
+		e := &c.entries[n]
+		prev := e.prev
+		buf = c.appendData(buf[:0], e, 0, int(e.keyLen)+int(e.valueLen))
+		if match(buf[:e.keyLen:e.keyLen], buf[e.keyLen:]) {
+			c.removeEntry(n, EvictDeleted)
+			i += 1
+		}
+		n = prev
+	}
+	return i
+}
+
+// Evict all items from the cache. O(n*log(n))
+func (c *ByteCache) Clear() int {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	i := 0
+	for c.tail >= 0 {
+		c.removeEntry(c.tail, EvictCleared)
+		i += 1
+	}
+	return i
+}
+
+// Evict all the expired items. O(n*log(n))
+func (c *ByteCache) Expire() int {
+	return c.ExpireNow(c.now())
+}
+
+// Evict items that expire before `now`. O(n*log(n))
+func (c *ByteCache) ExpireNow(now time.Time) int {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	i := 0
+	for {
+		n := c.expiredEntry(now)
+		if n < 0 {
+			break
+		}
+		c.removeEntry(n, EvictExpired)
+		i += 1
+	}
+	return i
+}
+
+// Number of entries used in the LRU
+func (c *ByteCache) Len() int {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	return c.used
+}
+
+// Capacity gets the total number of entries of the LRU
+func (c *ByteCache) Capacity() int {
+	return len(c.entries)
+}
+
+// Size gets the number of bytes of the arena taken by the items.
+func (c *ByteCache) Size() int {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	return (len(c.nextBlock) - c.freeBlocks) * byteBlockSize
+}
+
+// Stats returns the counters of the cache. Doesn't need the lock.
+func (c *ByteCache) Stats() Stats {
+	return c.stats.load()
+}
+
+// MultiByteCache is a sharded ByteCache, the same as MultiLRUCache is
+// for LRUCache. Never dereference it or copy it by value. Always use
+// it through a pointer.
+type MultiByteCache struct {
+	buckets uint
+	cache   []*ByteCache
+	seed    maphash.Seed
+
+	// Source of the current time of the shards, time.Now if nil.
+	// Must be set before Init.
+	Clock Clock
+}
+
+// Using this constructor is almost always wrong. Use NewMultiByteCache instead.
+func (m *MultiByteCache) Init(buckets, bucketCapacity, bucketSize uint) {
+	m.buckets = buckets
+	m.cache = make([]*ByteCache, buckets)
+	for i := uint(0); i < buckets; i++ {
+		m.cache[i] = &ByteCache{Clock: m.Clock}
+		m.cache[i].Init(bucketCapacity, bucketSize)
+	}
+	m.seed = maphash.MakeSeed()
+}
+
+// NewMultiByteCache creates a cache of the given number of shards,
+// each of at most bucketCapacity items taking at most bucketSize
+// bytes.
+func NewMultiByteCache(buckets, bucketCapacity, bucketSize uint) *MultiByteCache {
+	m := &MultiByteCache{}
+	m.Init(buckets, bucketCapacity, bucketSize)
+	return m
+}
+
+// Set the stale expiry grace period for each cache in the multicache instance.
+func (m *MultiByteCache) SetExpireGracePeriod(p time.Duration) {
+	for _, c := range m.cache {
+		c.ExpireGracePeriod = p
+	}
+}
+
+// Shards hash the keys with their own seeds, so that the keys of a
+// shard are spread over its whole table.
+func (m *MultiByteCache) bucketNo(key []byte) uint {
+	return uint(maphash.Bytes(m.seed, key) % uint64(m.buckets))
+}
+
+// Shard returns the number of the shard the key belongs to, the
+// index into ShardStats.
+func (m *MultiByteCache) Shard(key []byte) int {
+	return int(m.bucketNo(key))
+}
+
+func (m *MultiByteCache) Set(key, value []byte, expire time.Time) {
+	m.cache[m.bucketNo(key)].Set(key, value, expire)
+}
+
+func (m *MultiByteCache) SetNow(key, value []byte, expire time.Time, now time.Time) {
+	m.cache[m.bucketNo(key)].SetNow(key, value, expire, now)
+}
+
+func (m *MultiByteCache) Get(key []byte) (value []byte, ok bool) {
+	return m.cache[m.bucketNo(key)].Get(key)
+}
+
+func (m *MultiByteCache) GetQuiet(key []byte) (value []byte, ok bool) {
+	return m.cache[m.bucketNo(key)].GetQuiet(key)
+}
+
+func (m *MultiByteCache) GetWithExpire(key []byte) (value []byte, expire time.Time, ok bool) {
+	return m.cache[m.bucketNo(key)].GetWithExpire(key)
+}
+
+func (m *MultiByteCache) GetNotStale(key []byte) (value []byte, ok bool) {
+	return m.cache[m.bucketNo(key)].GetNotStale(key)
+}
+
+func (m *MultiByteCache) GetNotStaleNow(key []byte, now time.Time) (value []byte, ok bool) {
+	return m.cache[m.bucketNo(key)].GetNotStaleNow(key, now)
+}
+
+func (m *MultiByteCache) GetStale(key []byte) (value []byte, ok, expired bool) {
+	return m.cache[m.bucketNo(key)].GetStale(key)
+}
+
+func (m *MultiByteCache) GetStaleNow(key []byte, now time.Time) (value []byte, ok, expired bool) {
+	return m.cache[m.bucketNo(key)].GetStaleNow(key, now)
+}
+
+func (m *MultiByteCache) Del(key []byte) (value []byte, ok bool) {
+	return m.cache[m.bucketNo(key)].Del(key)
+}
+
+func (m *MultiByteCache) DelFunc(match func(key, value []byte) bool) int {
+	var s int
+	for _, c := range m.cache {
+		s += c.DelFunc(match)
+	}
+	return s
+}
+
+func (m *MultiByteCache) Clear() int {
+	var s int
+	for _, c := range m.cache {
+		s += c.Clear()
+	}
+	return s
+}
+
+func (m *MultiByteCache) Expire() int {
+	var s int
+	for _, c := range m.cache {
+		s += c.Expire()
+	}
+	return s
+}
+
+func (m *MultiByteCache) ExpireNow(now time.Time) int {
+	var s int
+	for _, c := range m.cache {
+		s += c.ExpireNow(now)
+	}
+	return s
+}
+
+func (m *MultiByteCache) Len() int {
+	var s int
+	for _, c := range m.cache {
+		s += c.Len()
+	}
+	return s
+}
+
+func (m *MultiByteCache) Capacity() int {
+	var s int
+	for _, c := range m.cache {
+		s += c.Capacity()
+	}
+	return s
+}
+
+func (m *MultiByteCache) Size() int {
+	var s int
+	for _, c := range m.cache {
+		s += c.Size()
+	}
+	return s
+}
+
+// Stats returns the counters summed over all the shards.
+func (m *MultiByteCache) Stats() Stats {
+	var s Stats
+	for _, c := range m.cache {
+		s.add(c.Stats())
+	}
+	return s
+}
+
+// ShardStats returns the counters of every shard separately.
+func (m *MultiByteCache) ShardStats() []Stats {
+	s := make([]Stats, len(m.cache))
+	for i, c := range m.cache {
+		s[i] = c.Stats()
+	}
+	return s
+}
@@ -0,0 +1,252 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"bytes"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestByteCacheBasic(t *testing.T) {
+	t.Parallel()
+	c := NewByteCache(3, 1024)
+
+	c.Set([]byte("a"), []byte("va"), time.Time{})
+	c.Set([]byte("b"), []byte("vb"), time.Time{})
+	c.Set([]byte("c"), []byte("vc"), time.Time{})
+	if c.Len() != 3 || c.Capacity() != 3 || c.Size() != 3*byteBlockSize {
+		t.Error("expecting different length")
+	}
+
+	c.Get([]byte("a"))
+	c.Set([]byte("d"), []byte("vd"), time.Time{})
+	if _, ok := c.GetQuiet([]byte("b")); ok {
+		t.Error("expecting B to be evicted")
+	}
+	if v, ok := c.Get([]byte("a")); !ok || string(v) != "va" {
+		t.Error("expecting A to stay")
+	}
+
+	// The value is a copy.
+	v := []byte("ve")
+	c.Set([]byte("c"), v, time.Time{})
+	v[0] = 'x'
+	if v, _ := c.Get([]byte("c")); string(v) != "ve" || c.Len() != 3 {
+		t.Error("expecting C to be overwritten")
+	}
+
+	if v, ok := c.Del([]byte("c")); !ok || string(v) != "ve" || c.Len() != 2 {
+		t.Error("expecting C to be deleted")
+	}
+	if _, ok := c.Del([]byte("c")); ok {
+		t.Error("expecting miss")
+	}
+
+	c.Set(nil, nil, time.Time{})
+	if v, ok := c.Get(nil); !ok || v == nil || len(v) != 0 {
+		t.Error("expecting the empty key to be found")
+	}
+
+	if c.Clear() != 3 || c.Len() != 0 || c.Size() != 0 {
+		t.Error("expecting the cache to be empty")
+	}
+	s := c.Stats()
+	if s.Evicted != 1 || s.Misses != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestByteCacheSize(t *testing.T) {
+	t.Parallel()
+	c := NewByteCache(10, 4*byteBlockSize)
+	big := bytes.Repeat([]byte("x"), 2*byteBlockSize)
+
+	c.Set([]byte("a"), []byte("va"), time.Time{})
+	c.Set([]byte("b"), []byte("vb"), time.Time{})
+	c.Set([]byte("c"), []byte("vc"), time.Time{})
+	c.Get([]byte("a"))
+	// Takes three blocks, B and C have to go.
+	c.Set([]byte("d"), big, time.Time{})
+	if c.Len() != 2 || c.Size() != 4*byteBlockSize {
+		t.Error("expecting different length")
+	}
+	if v, ok := c.Get([]byte("d")); !ok || !bytes.Equal(v, big) {
+		t.Error("expecting D to be stored")
+	}
+	if _, ok := c.Get([]byte("a")); !ok {
+		t.Error("expecting A to stay")
+	}
+
+	// Larger than the arena, replaces A but isn't stored.
+	c.Set([]byte("a"), bytes.Repeat(big, 2), time.Time{})
+	if _, ok := c.Get([]byte("a")); ok || c.Len() != 1 {
+		t.Error("expecting A to be dropped")
+	}
+
+	e := NewByteCache(0, 1024)
+	e.Set([]byte("a"), []byte("va"), time.Time{})
+	if _, ok := e.Get([]byte("a")); ok {
+		t.Error("expecting nothing to be stored")
+	}
+}
+
+func TestByteCacheExpiry(t *testing.T) {
+	t.Parallel()
+	c := NewByteCache(3, 1024)
+	now := time.Now()
+
+	c.Set([]byte("a"), []byte("va"), now.Add(time.Second))
+	c.Set([]byte("b"), []byte("vb"), now.Add(-time.Second))
+	c.Set([]byte("c"), []byte("vc"), now.Add(time.Minute))
+	if _, expire, _ := c.GetWithExpire([]byte("c")); !expire.Equal(now.Add(time.Minute)) {
+		t.Error("expecting the expiry time to be kept")
+	}
+	c.Get([]byte("b"))
+
+	// B is used, but expired.
+	c.Set([]byte("d"), []byte("vd"), time.Time{})
+	if _, ok := c.GetQuiet([]byte("b")); ok {
+		t.Error("expecting B to be evicted")
+	}
+
+	if _, ok, expired := c.GetStaleNow([]byte("a"), now.Add(2*time.Second)); !ok || !expired {
+		t.Error("expecting A to be stale")
+	}
+	if _, ok := c.GetNotStale([]byte("a")); !ok {
+		t.Error("expecting A to be fresh")
+	}
+	// Same as for LRUCache, an item without expiry is never stale.
+	if _, ok := c.GetNotStale([]byte("d")); !ok || c.Len() != 3 {
+		t.Error("expecting D not to be stale")
+	}
+
+	c.ExpireGracePeriod = time.Minute
+	if _, ok := c.GetNotStaleNow([]byte("a"), now.Add(2*time.Second)); ok || c.Len() != 3 {
+		t.Error("expecting A to be kept for the grace period")
+	}
+	if c.ExpireNow(now.Add(2*time.Second)) != 1 || c.Len() != 2 {
+		t.Error("expecting A to expire")
+	}
+	if c.Expire() != 0 {
+		t.Error("expecting C and D to stay")
+	}
+	if s := c.Stats(); s.Expired != 2 || s.StaleHits != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestByteCacheDelFunc(t *testing.T) {
+	t.Parallel()
+	c := NewByteCache(10, 1024)
+	for i := 0; i < 10; i++ {
+		c.Set([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i%2)), time.Time{})
+	}
+	n := c.DelFunc(func(key, value []byte) bool {
+		return string(value) == "1"
+	})
+	if n != 5 || c.Len() != 5 {
+		t.Error("expecting odd keys to be removed")
+	}
+	if _, ok := c.Get([]byte("3")); ok {
+		t.Error("expecting miss")
+	}
+}
+
+// Compare with a map, the cache is large enough never to evict.
+func TestByteCacheRandom(t *testing.T) {
+	t.Parallel()
+	c := NewByteCache(200, 200*3*byteBlockSize)
+	m := make(map[string]string)
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 100000; i++ {
+		k := strconv.Itoa(r.Intn(200))
+		switch r.Intn(3) {
+		case 0:
+			v := string(bytes.Repeat([]byte(k), r.Intn(50)))
+			c.Set([]byte(k), []byte(v), time.Time{})
+			m[k] = v
+		case 1:
+			_, ok := c.Del([]byte(k))
+			if _, ok2 := m[k]; ok != ok2 {
+				t.Fatalf("unexpected delete of %s", k)
+			}
+			delete(m, k)
+		case 2:
+			v, ok := c.Get([]byte(k))
+			if v2, ok2 := m[k]; ok != ok2 || string(v) != v2 {
+				t.Fatalf("unexpected value of %s", k)
+			}
+		}
+	}
+	if c.Len() != len(m) {
+		t.Error("expecting different length")
+	}
+}
+
+func TestByteCacheAllocs(t *testing.T) {
+	c := NewByteCache(100, 100*byteBlockSize)
+	key, value := []byte("key"), []byte("value")
+	n := testing.AllocsPerRun(100, func() {
+		c.Set(key, value, time.Time{})
+		c.GetQuiet(key)
+	})
+	// Just the copy of the value.
+	if n != 1 {
+		t.Errorf("expecting one allocation, got %v", n)
+	}
+}
+
+func TestMultiByteCache(t *testing.T) {
+	t.Parallel()
+	m := NewMultiByteCache(4, 20, 20*byteBlockSize)
+	for i := 0; i < 20; i++ {
+		m.Set([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)), time.Time{})
+	}
+	if m.Len() != 20 || m.Capacity() != 80 || m.Size() != 20*byteBlockSize {
+		t.Error("expecting different length")
+	}
+	for i := 0; i < 20; i++ {
+		if v, ok := m.Get([]byte(strconv.Itoa(i))); !ok || string(v) != strconv.Itoa(i) {
+			t.Errorf("expecting %d to be there", i)
+		}
+	}
+	k := []byte("5")
+	if s := m.ShardStats()[m.Shard(k)]; s.Hits == 0 {
+		t.Error("expecting the hit to be counted in the shard")
+	}
+	if m.Stats().Hits != 20 {
+		t.Error("expecting all the hits to be counted")
+	}
+
+	m.Del(k)
+	if m.Clear() != 19 || m.Len() != 0 {
+		t.Error("expecting the cache to be empty")
+	}
+}
+
+func BenchmarkConcurrentGetByteCache(bb *testing.B) {
+	c := NewMultiByteCache(16, 1000, 1000*byteBlockSize)
+	for i := 0; i < 1000; i++ {
+		c.Set([]byte(randomString(2)), []byte("value"), time.Time{})
+	}
+
+	cpu := runtime.GOMAXPROCS(0)
+	ch := make(chan bool)
+	worker := func() {
+		for i := 0; i < bb.N/cpu; i++ {
+			c.Get([]byte(randomString(2)))
+		}
+		ch <- true
+	}
+	for i := 0; i < cpu; i++ {
+		go worker()
+	}
+	for i := 0; i < cpu; i++ {
+		_ = <-ch
+	}
+}
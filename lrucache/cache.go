@@ -7,7 +7,7 @@
 // O(1). Modification O(log(n)) if expiry is used, O(1)
 // otherwise.
 //
-// This package exports three things:
+// This package exports four things:
 //  LRUCache: is the main implementation. It supports multithreading by
 //      using guarding mutex lock.
 //
@@ -17,25 +17,40 @@
 //      data structure instead of LRUCache if you have have lock
 //      contention issues.
 //
-//  Cache interface: Both implementations fulfill it.
+//  ClockCache: is a read optimized implementation. Lookups don't
+//      take any lock, eviction approximates LRU using the CLOCK
+//      algorithm. Use it for read heavy workloads.
+//
+//  Cache interface: All the implementations fulfill it.
+//
+// All four are generic over the key and value types. TypedLRUCache,
+// TypedMultiLRUCache, TypedClockCache and TypedCache take the key and
+// value types as type parameters, while LRUCache, MultiLRUCache,
+// ClockCache and Cache are aliases for their string keyed,
+// interface{} valued instances.
+//
+// ByteCache and MultiByteCache are variants of LRUCache and
+// MultiLRUCache for byte slice keys and values. They keep copies of
+// the items in preallocated memory the garbage collector doesn't
+// scan, use them for caches of millions of items.
 package lrucache
 
 import (
 	"time"
 )
 
-// Cache interface is fulfilled by the LRUCache and MultiLRUCache
-// implementations.
-type Cache interface {
+// TypedCache interface is fulfilled by the TypedLRUCache,
+// TypedMultiLRUCache and TypedClockCache implementations.
+type TypedCache[K comparable, V any] interface {
 	// Methods not needing to know current time.
 	//
 	// Get a key from the cache, possibly stale. Update its LRU
 	// score.
-	Get(key string) (value interface{}, ok bool)
+	Get(key K) (value V, ok bool)
 	// Get a key from the cache, possibly stale. Don't modify its LRU score. O(1)
-	GetQuiet(key string) (value interface{}, ok bool)
+	GetQuiet(key K) (value V, ok bool)
 	// Get and remove a key from the cache.
-	Del(key string) (value interface{}, ok bool)
+	Del(key K) (value V, ok bool)
 	// Evict all items from the cache.
 	Clear() int
 	// Number of entries used in the LRU
@@ -43,16 +58,28 @@ type Cache interface {
 	// Get the total capacity of the LRU
 	Capacity() int
 
-	// Methods use time.Now() when neccessary to determine expiry.
+	// Methods use the Clock of the cache, time.Now() by default,
+	// when neccessary to determine expiry.
 	//
 	// Add an item to the cache overwriting existing one if it
-	// exists.
-	Set(key string, value interface{}, expire time.Time)
+	// exists. Zero expire means the item never expires.
+	Set(key K, value V, expire time.Time)
 	// Get a key from the cache, make sure it's not stale. Update
 	// its LRU score.
-	GetNotStale(key string) (value interface{}, ok bool)
+	GetNotStale(key K) (value V, ok bool)
 	// Evict all the expired items.
 	Expire() int
+	// Add an item only if the key is absent or expired.
+	SetIfAbsent(key K, value V, expire time.Time) bool
+	// Overwrite an item only if the key is present and not
+	// expired.
+	Replace(key K, value V, expire time.Time) bool
+	// Swap the value of a key that is present and not expired if
+	// it's equal to old.
+	CompareAndSwap(key K, old, new V) bool
+	// Atomically replace the value of a key with the result of a
+	// function, or delete the key.
+	Update(key K, fn func(old V, ok bool) (new V, expire time.Time, keep bool)) (value V, ok bool)
 
 	// Methods allowing to explicitly specify time used to
 	// determine if items are expired.
@@ -60,10 +87,14 @@ type Cache interface {
 	// Add an item to the cache overwriting existing one if it
 	// exists. Allows specifing current time required to expire an
 	// item when no more slots are used.
-	SetNow(key string, value interface{}, expire time.Time, now time.Time)
+	SetNow(key K, value V, expire time.Time, now time.Time)
 	// Get a key from the cache, make sure it's not stale. Update
 	// its LRU score.
-	GetNotStaleNow(key string, now time.Time) (value interface{}, ok bool)
+	GetNotStaleNow(key K, now time.Time) (value V, ok bool)
 	// Evict items that expire before Now.
 	ExpireNow(now time.Time) int
 }
+
+// Cache interface is fulfilled by the LRUCache, MultiLRUCache and
+// ClockCache implementations.
+type Cache = TypedCache[string, interface{}]
@@ -0,0 +1,452 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The key, value and expiry time of an entry of ClockCache. Never
+// modified, the writers replace it as a whole so that lookups can read
+// it without the lock.
+type clockItem[K comparable, V any] struct {
+	key    K
+	value  V
+	expire time.Time
+}
+
+// Every entry of ClockCache has a reference bit, set by lookups and
+// cleared by the CLOCK hand.
+type clockEntry[K comparable, V any] struct {
+	item       atomic.Pointer[clockItem[K, V]] // nil if the entry is free
+	index      int                             // in expiryQueue, -1 if not there
+	referenced atomic.Bool
+}
+
+// TypedClockCache is a read optimized alternative to TypedLRUCache.
+// Lookups don't take any lock, they find the entry in a sync.Map and
+// at most set its reference bit, so concurrent Get calls don't
+// contend. Only the writers are serialized. Eviction uses the CLOCK
+// algorithm: the hand goes round the entries, clearing the reference
+// bits, and evicts the first entry that wasn't used since the hand
+// last passed it. Expired entries are evicted first. The order is an
+// approximation of LRU. Never dereference it or copy it by value.
+// Always use it through a pointer.
+type TypedClockCache[K comparable, V any] struct {
+	lock        sync.Mutex         // held by the writers
+	table       sync.Map           // K to *clockEntry, read without the lock
+	entries     []clockEntry[K, V] // allocated on Init
+	free        []*clockEntry[K, V]
+	hand        int
+	expiryQueue clockQueue[K, V]
+	stats       stats
+	reads       readStats // hits and misses, see readStats
+
+	// Expired entries are kept and returned by Get for this long,
+	// same as in TypedLRUCache. Must be set before the cache is
+	// used.
+	ExpireGracePeriod time.Duration
+
+	// Callback run when an entry is removed from the cache, called
+	// outside the lock. Must be set before the cache is used.
+	OnEvict func(key K, value V, reason EvictReason)
+
+	// Source of the current time, time.Now if nil. Must be set
+	// before the cache is used.
+	Clock Clock
+}
+
+// ClockCache is the string keyed, interface{} valued ClockCache.
+type ClockCache = TypedClockCache[string, interface{}]
+
+// Using this constructor is almost always wrong. Use NewClockCache instead.
+func (c *TypedClockCache[K, V]) Init(capacity uint) {
+	c.table.Clear()
+	c.entries = make([]clockEntry[K, V], capacity)
+	c.free = make([]*clockEntry[K, V], capacity)
+	for i := range c.entries {
+		e := &c.entries[i]
+		e.index = -1
+		c.free[len(c.free)-1-i] = e
+	}
+	c.hand = 0
+	c.expiryQueue = make(clockQueue[K, V], 0, capacity)
+	c.reads = newReadStats()
+}
+
+func NewClockCache(capacity uint) *ClockCache {
+	return NewTypedClockCache[string, interface{}](capacity)
+}
+
+func NewTypedClockCache[K comparable, V any](capacity uint) *TypedClockCache[K, V] {
+	c := &TypedClockCache[K, V]{}
+	c.Init(capacity)
+	return c
+}
+
+// Only write the bit if it's not set already, not to bounce the
+// cache line between CPUs on every lookup.
+func (e *clockEntry[K, V]) touch() {
+	if !e.referenced.Load() {
+		e.referenced.Store(true)
+	}
+}
+
+// Find the entry of the key and its item. Safe without the lock: the
+// entry may be freed and reused for another key right after it's
+// found in the table, so the key of the item is checked.
+func (c *TypedClockCache[K, V]) load(key K) (*clockEntry[K, V], *clockItem[K, V]) {
+	v, ok := c.table.Load(key)
+	if !ok {
+		return nil, nil
+	}
+	e := v.(*clockEntry[K, V])
+	it := e.item.Load()
+	if it == nil || it.key != key {
+		return nil, nil
+	}
+	return e, it
+}
+
+// Take the lock, counting the times it was held by someone else.
+func (c *TypedClockCache[K, V]) acquireLock() {
+	if !c.lock.TryLock() {
+		c.stats.contended.Add(1)
+		c.lock.Lock()
+	}
+}
+
+// Take an entry to be reused. Must be called with the lock held.
+func (c *TypedClockCache[K, V]) freeSomeEntry(now time.Time, evs *[]evicted[K, V]) *clockEntry[K, V] {
+	if n := len(c.free); n > 0 {
+		e := c.free[n-1]
+		c.free = c.free[:n-1]
+		return e
+	}
+	if len(c.entries) == 0 {
+		return nil
+	}
+	if len(c.expiryQueue) > 0 && c.expiryQueue[0].expire().Before(now) {
+		c.evictEntry(c.expiryQueue[0], EvictExpired, evs)
+		return c.freeSomeEntry(now, evs)
+	}
+	for {
+		e := &c.entries[c.hand]
+		c.hand = (c.hand + 1) % len(c.entries)
+		if e.referenced.Load() {
+			e.referenced.Store(false)
+			continue
+		}
+		c.evictEntry(e, EvictCapacity, evs)
+		return c.freeSomeEntry(now, evs)
+	}
+}
+
+// Remove the entry. If the OnEvict callback is set remember the key
+// and value for notifyEvicted. Must be called with the lock held.
+func (c *TypedClockCache[K, V]) evictEntry(e *clockEntry[K, V], reason EvictReason, evs *[]evicted[K, V]) {
+	c.stats.countEviction(reason)
+	if c.OnEvict != nil {
+		it := e.item.Load()
+		*evs = append(*evs, evicted[K, V]{it.key, it.value, reason})
+	}
+	c.removeEntry(e)
+}
+
+// Run the OnEvict callback for every removed entry. Must be called
+// outside the lock, the callback may want to use the cache.
+func (c *TypedClockCache[K, V]) notifyEvicted(evs *[]evicted[K, V]) {
+	for _, ev := range *evs {
+		c.OnEvict(ev.key, ev.value, ev.reason)
+	}
+}
+
+// Unlink the entry and put it on the free list. Must be called with
+// the lock held.
+func (c *TypedClockCache[K, V]) removeEntry(e *clockEntry[K, V]) {
+	if e.index != -1 {
+		heap.Remove(&c.expiryQueue, e.index)
+	}
+	c.table.Delete(e.item.Load().key)
+	e.item.Store(nil)
+	e.referenced.Store(false)
+	c.free = append(c.free, e)
+}
+
+// Publish a new item of the entry, keeping the expiry queue in order.
+// Must be called with the lock held.
+func (c *TypedClockCache[K, V]) store(e *clockEntry[K, V], key K, value V, expire time.Time) {
+	e.item.Store(&clockItem[K, V]{key, value, expire})
+	switch {
+	case e.index != -1 && expire.IsZero():
+		heap.Remove(&c.expiryQueue, e.index)
+	case e.index != -1:
+		heap.Fix(&c.expiryQueue, e.index)
+	case !expire.IsZero():
+		heap.Push(&c.expiryQueue, e)
+	}
+}
+
+// Set an item in the cache, overwriting existing one if it exists.
+// Allows specifing current time required to expire an item when no
+// more slots are used. O(log(n)) if expiry is set, amortized O(1)
+// otherwise.
+func (c *TypedClockCache[K, V]) SetNow(key K, value V, expire time.Time, now time.Time) {
+	var evs []evicted[K, V]
+	defer c.notifyEvicted(&evs)
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	c.set(key, value, expire, now, &evs)
+}
+
+// Add or overwrite an item. Must be called with the lock held.
+func (c *TypedClockCache[K, V]) set(key K, value V, expire time.Time, now time.Time, evs *[]evicted[K, V]) {
+	if e, it := c.load(key); e != nil {
+		if c.OnEvict != nil {
+			*evs = append(*evs, evicted[K, V]{key, it.value, EvictOverwritten})
+		}
+		c.store(e, key, value, expire)
+		e.touch()
+		return
+	}
+
+	e := c.freeSomeEntry(now, evs)
+	if e == nil {
+		return
+	}
+	c.store(e, key, value, expire)
+	c.table.Store(key, e)
+}
+
+// Set an item in the cache, overwriting existing one if it exists.
+func (c *TypedClockCache[K, V]) Set(key K, value V, expire time.Time) {
+	c.SetNow(key, value, expire, c.now())
+}
+
+// Get a key from the cache, possibly stale. Sets its reference bit.
+// Lock free. O(1)
+func (c *TypedClockCache[K, V]) Get(key K) (value V, ok bool) {
+	value, _, ok = c.GetWithExpire(key)
+	return value, ok
+}
+
+// Get a key from the cache, possibly stale. Doesn't set its
+// reference bit. Lock free. O(1)
+func (c *TypedClockCache[K, V]) GetQuiet(key K) (value V, ok bool) {
+	_, it := c.load(key)
+	if it == nil {
+		c.reads.slot().misses.Add(1)
+		return value, false
+	}
+	c.reads.slot().hits.Add(1)
+	return it.value, true
+}
+
+// Get a key from the cache, possibly stale, together with its expiry
+// time. Sets its reference bit. Lock free. O(1)
+func (c *TypedClockCache[K, V]) GetWithExpire(key K) (value V, expire time.Time, ok bool) {
+	e, it := c.load(key)
+	if e == nil {
+		c.reads.slot().misses.Add(1)
+		return value, expire, false
+	}
+	c.reads.slot().hits.Add(1)
+	e.touch()
+	return it.value, it.expire, true
+}
+
+// Get a key from the cache, make sure it's not stale. Sets its
+// reference bit. O(1), O(log(n)) if an expired entry is removed.
+func (c *TypedClockCache[K, V]) GetNotStale(key K) (value V, ok bool) {
+	return c.GetNotStaleNow(key, c.now())
+}
+
+// Get a key from the cache, make sure it's not stale. Lock free,
+// unless an entry expired for more than the ExpireGracePeriod is
+// removed.
+func (c *TypedClockCache[K, V]) GetNotStaleNow(key K, now time.Time) (value V, ok bool) {
+	e, it := c.load(key)
+	if e == nil {
+		c.reads.slot().misses.Add(1)
+		return value, false
+	}
+	if !hasExpired(it.expire, now) {
+		c.reads.slot().hits.Add(1)
+		e.touch()
+		return it.value, true
+	}
+	c.reads.slot().misses.Add(1)
+
+	if c.ExpireGracePeriod == 0 || now.Sub(it.expire) > c.ExpireGracePeriod {
+		var evs []evicted[K, V]
+		defer c.notifyEvicted(&evs)
+		c.acquireLock()
+		// Someone may have replaced the entry in the meantime.
+		if e, it := c.load(key); e != nil && hasExpired(it.expire, now) {
+			c.evictEntry(e, EvictExpired, &evs)
+		}
+		c.lock.Unlock()
+	}
+	return value, false
+}
+
+// Get a key from the cache, possibly stale. Sets its reference bit.
+// Lock free. O(1)
+func (c *TypedClockCache[K, V]) GetStale(key K) (value V, ok, expired bool) {
+	return c.GetStaleNow(key, c.now())
+}
+
+// Get a key from the cache, possibly stale, telling whether it has
+// expired at now. Sets its reference bit. Lock free. O(1)
+func (c *TypedClockCache[K, V]) GetStaleNow(key K, now time.Time) (value V, ok, expired bool) {
+	e, it := c.load(key)
+	if e == nil {
+		c.reads.slot().misses.Add(1)
+		return value, false, false
+	}
+	expired = hasExpired(it.expire, now)
+	if expired {
+		c.reads.slot().staleHits.Add(1)
+	} else {
+		c.reads.slot().hits.Add(1)
+	}
+	e.touch()
+	return it.value, true, expired
+}
+
+func (c *TypedClockCache[K, V]) expireOf(key K) (expire time.Time, ok bool) {
+	if _, it := c.load(key); it != nil {
+		return it.expire, true
+	}
+	return expire, false
+}
+
+// Get and remove a key from the cache. O(log(n)) if the item is using
+// expiry, O(1) otherwise.
+func (c *TypedClockCache[K, V]) Del(key K) (value V, ok bool) {
+	var evs []evicted[K, V]
+	defer c.notifyEvicted(&evs)
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	if e, it := c.load(key); e != nil {
+		c.evictEntry(e, EvictDeleted, &evs)
+		return it.value, true
+	}
+	return value, false
+}
+
+// DelFunc removes all the entries for which match returns true and
+// returns their number. Match is run with the lock held and must not
+// use the cache. O(n)
+func (c *TypedClockCache[K, V]) DelFunc(match func(key K, value V) bool) int {
+	return c.delFunc(match, EvictDeleted)
+}
+
+func (c *TypedClockCache[K, V]) delFunc(match func(key K, value V) bool, reason EvictReason) int {
+	var evs []evicted[K, V]
+	defer c.notifyEvicted(&evs)
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	var matched []*clockEntry[K, V]
+	for i := range c.entries {
+		e := &c.entries[i]
+		if it := e.item.Load(); it != nil && match(it.key, it.value) {
+			matched = append(matched, e)
+		}
+	}
+	for _, e := range matched {
+		c.evictEntry(e, reason, &evs)
+	}
+	return len(matched)
+}
+
+// Evict all items from the cache. O(n*log(n))
+func (c *TypedClockCache[K, V]) Clear() int {
+	return c.delFunc(func(key K, value V) bool {
+		return true
+	}, EvictCleared)
+}
+
+// Evict all the expired items. O(n*log(n))
+func (c *TypedClockCache[K, V]) Expire() int {
+	return c.ExpireNow(c.now())
+}
+
+// Evict items that expire before Now. O(n*log(n))
+func (c *TypedClockCache[K, V]) ExpireNow(now time.Time) int {
+	var evs []evicted[K, V]
+	defer c.notifyEvicted(&evs)
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	i := 0
+	for len(c.expiryQueue) > 0 && c.expiryQueue[0].expire().Before(now) {
+		c.evictEntry(c.expiryQueue[0], EvictExpired, &evs)
+		i++
+	}
+	return i
+}
+
+// Number of entries used in the cache. O(1)
+func (c *TypedClockCache[K, V]) Len() int {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	return len(c.entries) - len(c.free)
+}
+
+// Get the total capacity of the cache. O(1)
+func (c *TypedClockCache[K, V]) Capacity() int {
+	return len(c.entries)
+}
+
+// Stats returns the counters of the cache. Doesn't need the lock.
+func (c *TypedClockCache[K, V]) Stats() Stats {
+	s := c.stats.load()
+	c.reads.addTo(&s)
+	return s
+}
+
+// Expiry time of an entry in the expiry queue. Must be called with
+// the lock held.
+func (e *clockEntry[K, V]) expire() time.Time {
+	return e.item.Load().expire
+}
+
+// Expiry times of the entries, ordered as a heap.
+type clockQueue[K comparable, V any] []*clockEntry[K, V]
+
+func (q clockQueue[K, V]) Len() int {
+	return len(q)
+}
+
+func (q clockQueue[K, V]) Less(i, j int) bool {
+	return q[i].expire().Before(q[j].expire())
+}
+
+func (q clockQueue[K, V]) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *clockQueue[K, V]) Push(e interface{}) {
+	item := e.(*clockEntry[K, V])
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *clockQueue[K, V]) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	item.index = -1
+	*q = old[0 : n-1]
+	return item
+}
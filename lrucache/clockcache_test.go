@@ -0,0 +1,238 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+var _ Cache = NewClockCache(1)
+var _ staleCache[string, interface{}] = NewClockCache(1)
+
+func TestClockBasic(t *testing.T) {
+	t.Parallel()
+	c := NewTypedClockCache[string, int](3)
+
+	c.Set("a", 1, time.Time{})
+	c.Set("b", 2, time.Time{})
+	c.Set("c", 3, time.Time{})
+	if c.Len() != 3 || c.Capacity() != 3 {
+		t.Error("expecting different length")
+	}
+
+	// A and C were used, B is evicted.
+	c.Get("a")
+	c.Get("c")
+	c.Set("d", 4, time.Time{})
+	if _, ok := c.GetQuiet("b"); ok {
+		t.Error("expecting B to be evicted")
+	}
+	for k, v := range map[string]int{"a": 1, "c": 3, "d": 4} {
+		if got, ok := c.GetQuiet(k); !ok || got != v {
+			t.Errorf("expecting %s to be %d", k, v)
+		}
+	}
+
+	c.Set("a", 10, time.Time{})
+	if v, ok := c.Get("a"); !ok || v != 10 || c.Len() != 3 {
+		t.Error("expecting A to be overwritten")
+	}
+	if v, ok := c.Del("a"); !ok || v != 10 || c.Len() != 2 {
+		t.Error("expecting A to be deleted")
+	}
+	if _, ok := c.Del("a"); ok {
+		t.Error("expecting A to be gone")
+	}
+	if c.Clear() != 2 || c.Len() != 0 {
+		t.Error("expecting empty cache")
+	}
+}
+
+func TestClockExpiry(t *testing.T) {
+	t.Parallel()
+	c := NewTypedClockCache[string, int](3)
+	now := time.Now()
+	past := now.Add(-time.Second)
+	future := now.Add(time.Minute)
+
+	c.Set("a", 1, past)
+	c.Set("b", 2, future)
+	c.Set("c", 3, time.Time{})
+	c.Get("a")
+
+	// Expired A is evicted first, even though it was used.
+	c.SetNow("d", 4, time.Time{}, now)
+	if _, ok := c.GetQuiet("a"); ok {
+		t.Error("expecting A to be evicted")
+	}
+
+	if v, ok := c.GetNotStaleNow("b", now); !ok || v != 2 {
+		t.Error("expecting B not to be stale")
+	}
+	if _, expire, ok := c.GetWithExpire("b"); !ok || !expire.Equal(future) {
+		t.Error("expecting different expiry")
+	}
+	c.Set("b", 2, past)
+	if c.ExpireNow(now) != 1 || c.Len() != 2 {
+		t.Error("expecting B to expire")
+	}
+
+	c.ExpireGracePeriod = time.Minute
+	c.Set("e", 5, past)
+	if _, ok := c.GetNotStaleNow("e", now); ok {
+		t.Error("expecting E to be stale")
+	}
+	if _, ok := c.GetQuiet("e"); !ok {
+		t.Error("expecting E to be kept during the grace period")
+	}
+	if _, ok := c.GetNotStaleNow("e", now.Add(2*time.Minute)); ok {
+		t.Error("expecting E to be stale")
+	}
+	if _, ok := c.GetQuiet("e"); ok {
+		t.Error("expecting E to be removed after the grace period")
+	}
+}
+
+func TestClockZeroCapacity(t *testing.T) {
+	t.Parallel()
+	c := NewClockCache(0)
+	c.Set("a", 1, time.Time{})
+	if _, ok := c.Get("a"); ok || c.Len() != 0 {
+		t.Error("expecting nothing to be stored")
+	}
+}
+
+func TestClockConcurrent(t *testing.T) {
+	t.Parallel()
+	c := NewTypedClockCache[string, int](100)
+	past := time.Now().Add(-time.Second)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k := strconv.Itoa(i % 300)
+				switch i % 5 {
+				case 0:
+					c.Set(k, i, time.Time{})
+				case 1:
+					c.Set(k, i, past)
+				case 2:
+					c.GetNotStale(k)
+				case 3:
+					c.Del(k)
+				default:
+					// Entries get reused for other keys
+					// under the lookups.
+					if v, ok := c.Get(k); ok && strconv.Itoa(v%300) != k {
+						t.Errorf("expecting a value of %s, got %d", k, v)
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if c.Len() > c.Capacity() {
+		t.Error("expecting no more entries than capacity")
+	}
+}
+
+func TestClockOnEvict(t *testing.T) {
+	t.Parallel()
+	c := NewTypedClockCache[string, int](2)
+	now := time.Now()
+	var got []string
+	c.OnEvict = func(key string, value int, reason EvictReason) {
+		got = append(got, key+strconv.Itoa(value)+" "+reason.String())
+		// The lock must not be held.
+		c.Len()
+	}
+
+	c.Set("a", 1, time.Time{})
+	c.Set("a", 2, time.Time{})
+	c.Set("b", 3, now.Add(-time.Second))
+	c.SetNow("c", 4, time.Time{}, now)
+	// A was overwritten since the hand last passed it, C wasn't.
+	c.Set("d", 5, time.Time{})
+	c.Del("a")
+	c.Replace("d", 6, time.Time{})
+	c.Clear()
+	want := []string{"a1 overwritten", "b3 expired", "c4 capacity", "a2 deleted", "d6 cleared"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expecting %v, got %v", want, got)
+	}
+	if s := c.Stats(); s.Expired != 1 || s.Evicted != 1 {
+		t.Errorf("unexpected eviction counters %+v", s)
+	}
+}
+
+func TestClockStats(t *testing.T) {
+	t.Parallel()
+	c := NewTypedClockCache[string, int](10)
+	now := time.Now()
+	c.Set("a", 1, now.Add(time.Minute))
+	c.Set("b", 2, now.Add(-time.Second))
+
+	c.Get("a")
+	c.GetQuiet("a")
+	c.Get("x")
+	c.GetNotStaleNow("b", now)
+	if _, ok, expired := c.GetStaleNow("a", now); !ok || expired {
+		t.Error("expecting A not to be expired")
+	}
+	c.ExpireGracePeriod = time.Minute
+	c.Set("b", 2, now.Add(-time.Second))
+	if v, ok, expired := c.GetStaleNow("b", now); !ok || !expired || v != 2 {
+		t.Error("expecting B to be served stale")
+	}
+	want := Stats{Hits: 3, Misses: 2, StaleHits: 1, Expired: 1}
+	if s := c.Stats(); s != want {
+		t.Errorf("expecting %+v, got %+v", want, s)
+	}
+}
+
+func BenchmarkConcurrentGetClockCache(bb *testing.B) {
+	c := NewClockCache(1000)
+	for i := 0; i < 1000; i++ {
+		c.Set(randomString(2), "value", time.Time{})
+	}
+
+	cpu := runtime.GOMAXPROCS(0)
+	ch := make(chan bool)
+	worker := func() {
+		for i := 0; i < bb.N/cpu; i++ {
+			c.Get(randomString(2))
+		}
+		ch <- true
+	}
+	for i := 0; i < cpu; i++ {
+		go worker()
+	}
+	for i := 0; i < cpu; i++ {
+		_ = <-ch
+	}
+}
+
+// Run with -cpu 1,2,4,8, lookups don't write to shared memory, so the
+// time per lookup should go down with more CPUs.
+func BenchmarkParallelGetClockCache(bb *testing.B) {
+	c := NewTypedClockCache[int, int](1000)
+	for i := 0; i < 1000; i++ {
+		c.Set(i, i, time.Time{})
+	}
+
+	bb.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Get(i % 2000)
+			i++
+		}
+	})
+}
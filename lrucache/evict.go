@@ -0,0 +1,69 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+// EvictReason tells the OnEvict callback why an entry was removed
+// from the cache.
+type EvictReason int
+
+const (
+	// The entry was the least used one and its slot was needed
+	// for a new item.
+	EvictCapacity EvictReason = iota
+	// The entry expired. It was either purged by Expire, found
+	// stale by GetNotStale or its slot was reused for a new item.
+	EvictExpired
+	// The entry was removed by Del.
+	EvictDeleted
+	// The entry was replaced by Set or SetNow with the same key,
+	// or its new value didn't fit with the Weigher set. Changes made
+	// by Replace, CompareAndSwap and Update are not reported.
+	EvictOverwritten
+	// The entry was removed by Clear.
+	EvictCleared
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictDeleted:
+		return "deleted"
+	case EvictOverwritten:
+		return "overwritten"
+	case EvictCleared:
+		return "cleared"
+	}
+	return "unknown"
+}
+
+// A copy of a removed entry, kept until the cache lock is released
+// and the OnEvict callback can be run.
+type evicted[K comparable, V any] struct {
+	key    K
+	value  V
+	reason EvictReason
+}
+
+// Remove the entry. If the OnEvict callback is set remember the key
+// and value for notifyEvicted.
+func (b *TypedLRUCache[K, V]) evictEntry(e *entry[K, V], reason EvictReason, evs *[]evicted[K, V]) {
+	b.stats.countEviction(reason)
+	if b.policy != nil && reason == EvictCapacity {
+		b.policy.evicted(e)
+	}
+	if b.OnEvict != nil {
+		*evs = append(*evs, evicted[K, V]{e.key, e.value, reason})
+	}
+	b.removeEntry(e)
+}
+
+// Run the OnEvict callback for every removed entry. Must be called
+// outside the lock, the callback may want to use the cache.
+func (b *TypedLRUCache[K, V]) notifyEvicted(evs *[]evicted[K, V]) {
+	for _, ev := range *evs {
+		b.OnEvict(ev.key, ev.value, ev.reason)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"hash/maphash"
+	"math/bits"
+)
+
+// Hasher maps a key to a shard of TypedMultiLRUCache. Keys that hash
+// to the same value modulo the number of shards end up in the same
+// shard, a custom Hasher can use that to keep related keys together.
+type Hasher[K comparable] func(key K) uint64
+
+// SipHash returns a Hasher computing SipHash-2-4 of the key with the
+// given 128-bit secret key. Unless the secret is known, it's not
+// possible to pick keys that all land in the same shard.
+func SipHash(k0, k1 uint64) Hasher[string] {
+	return func(key string) uint64 {
+		return sipHash(k0, k1, key)
+	}
+}
+
+// RandomSipHash returns a SipHash Hasher with a random secret key.
+func RandomSipHash() Hasher[string] {
+	var k [16]byte
+	if _, err := rand.Read(k[:]); err != nil {
+		panic(err)
+	}
+	return SipHash(binary.LittleEndian.Uint64(k[:8]), binary.LittleEndian.Uint64(k[8:]))
+}
+
+// XXHash returns a Hasher computing XXH64 of the key. It's faster
+// than SipHash, but the seed doesn't protect from keys crafted to
+// collide.
+func XXHash(seed uint64) Hasher[string] {
+	return func(key string) uint64 {
+		return xxHash(seed, key)
+	}
+}
+
+// String keys are hashed with a randomly keyed SipHash, other key
+// types with a randomly seeded maphash.
+func defaultHash[K comparable]() Hasher[K] {
+	var k K
+	if _, ok := any(k).(string); ok {
+		h := RandomSipHash()
+		return func(key K) uint64 {
+			return h(any(key).(string))
+		}
+	}
+	seed := maphash.MakeSeed()
+	return func(key K) uint64 {
+		return maphash.Comparable(seed, key)
+	}
+}
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
+
+// Little endian reads from a string, without converting it to a
+// byte slice.
+func u64(p string) uint64 {
+	return uint64(p[0]) | uint64(p[1])<<8 | uint64(p[2])<<16 | uint64(p[3])<<24 |
+		uint64(p[4])<<32 | uint64(p[5])<<40 | uint64(p[6])<<48 | uint64(p[7])<<56
+}
+
+func u32(p string) uint32 {
+	return uint32(p[0]) | uint32(p[1])<<8 | uint32(p[2])<<16 | uint32(p[3])<<24
+}
+
+// xxHash returns the 64-bit XXH64 of the given string, see
+// https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md
+func xxHash(seed uint64, p string) uint64 {
+	n := len(p)
+	var h uint64
+	if n >= 32 {
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for len(p) >= 32 {
+			v1 = xxRound(v1, u64(p[0:8]))
+			v2 = xxRound(v2, u64(p[8:16]))
+			v3 = xxRound(v3, u64(p[16:24]))
+			v4 = xxRound(v4, u64(p[24:32]))
+			p = p[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = seed + xxPrime5
+	}
+	h += uint64(n)
+
+	for ; len(p) >= 8; p = p[8:] {
+		h ^= xxRound(0, u64(p))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(p) >= 4 {
+		h ^= uint64(u32(p)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		p = p[4:]
+	}
+	for i := 0; i < len(p); i++ {
+		h ^= uint64(p[i]) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSipHash(t *testing.T) {
+	t.Parallel()
+	// Reference vectors from the SipHash paper, key 00..0f and
+	// messages 00, 00 01, ...
+	k0, k1 := uint64(0x0706050403020100), uint64(0x0f0e0d0c0b0a0908)
+	msg := make([]byte, 15)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	if h := SipHash(k0, k1)(""); h != 0x726fdb47dd0e0e31 {
+		t.Errorf("unexpected hash %x", h)
+	}
+	if h := SipHash(k0, k1)(string(msg)); h != 0xa129ca6149be45e5 {
+		t.Errorf("unexpected hash %x", h)
+	}
+}
+
+func TestXXHash(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		key  string
+		hash uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"hello, world", 0xb33a384e6d1b1242},
+		{"0123456789abcdef0123456789abcdef", 0x642a94958e71e6c5},
+		{"The quick brown fox jumps over the lazy dog, twice or more", 0x2fdd83d8bc1d7d75},
+	}
+	h := XXHash(0)
+	for _, tt := range tests {
+		if got := h(tt.key); got != tt.hash {
+			t.Errorf("%q: expecting %x, got %x", tt.key, tt.hash, got)
+		}
+	}
+}
+
+func TestHashNoAlloc(t *testing.T) {
+	h := defaultHash[string]()
+	key := strings.Repeat("k", 100)
+	if n := testing.AllocsPerRun(100, func() { h(key) }); n != 0 {
+		t.Errorf("expecting no allocations, got %v", n)
+	}
+}
+
+func TestMultiLRUHash(t *testing.T) {
+	t.Parallel()
+
+	// Keep keys with the same prefix in the same shard.
+	m := &MultiLRUCache{
+		Hash: func(key string) uint64 {
+			prefix, _, _ := strings.Cut(key, ":")
+			return XXHash(0)(prefix)
+		},
+	}
+	m.Init(8, 10)
+
+	for _, k := range []string{"a:1", "a:2", "a:3"} {
+		m.Set(k, k, time.Time{})
+		if m.Shard(k) != m.Shard("a:") {
+			t.Error("expecting keys to be in the same shard")
+		}
+	}
+	if m.shards.Load().cache[m.Shard("a:")].Len() != 3 {
+		t.Error("expecting different shard length")
+	}
+}
+
+func TestContended(t *testing.T) {
+	t.Parallel()
+
+	b := NewLRUCache(10)
+	b.acquireLock()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b.Get("a")
+	}()
+	for b.Stats().Contended == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	b.lock.Unlock()
+	wg.Wait()
+	if s := b.Stats(); s.Contended != 1 || s.Misses != 1 {
+		t.Error("expecting contended lookup")
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Copy all the used entries, in the LRU order.
+func (b *TypedLRUCache[K, V]) copyEntries(mruFirst bool) []snapshotEntry[K, V] {
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.copyEntriesLocked(mruFirst)
+}
+
+// Same as copyEntries, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) copyEntriesLocked(mruFirst bool) []snapshotEntry[K, V] {
+	entries := make([]snapshotEntry[K, V], 0, b.usedLen())
+	b.walkEntries(mruFirst, func(e *entry[K, V]) bool {
+		entries = append(entries, snapshotEntry[K, V]{e.key, e.value, e.expire, e.tags, e.idle, e.deadline})
+		return true
+	})
+	return entries
+}
+
+func rangeEntries[K comparable, V any](entries []snapshotEntry[K, V], fn func(key K, value V, expire time.Time) bool) bool {
+	for i := range entries {
+		e := &entries[i]
+		if !fn(e.key, e.value, e.expire) {
+			return false
+		}
+	}
+	return true
+}
+
+// Range calls fn for every entry, possibly stale, from the most
+// recently used one. Stops if fn returns false. The entries are
+// copied first and fn is run outside the lock, so it may use the
+// cache, but won't see changes made after Range started. Doesn't
+// modify LRU scores. O(n)
+func (b *TypedLRUCache[K, V]) Range(fn func(key K, value V, expire time.Time) bool) {
+	rangeEntries(b.copyEntries(true), fn)
+}
+
+// ReverseRange is like Range, but starts from the least recently used
+// entry. O(n)
+func (b *TypedLRUCache[K, V]) ReverseRange(fn func(key K, value V, expire time.Time) bool) {
+	rangeEntries(b.copyEntries(false), fn)
+}
+
+// Sort entries with expiry set by the expiry time, drop the others.
+func sortByExpiry[K comparable, V any](entries []snapshotEntry[K, V]) []snapshotEntry[K, V] {
+	withExpiry := entries[:0]
+	for _, e := range entries {
+		if !e.expire.IsZero() {
+			withExpiry = append(withExpiry, e)
+		}
+	}
+	sort.SliceStable(withExpiry, func(i, j int) bool {
+		return withExpiry[i].expire.Before(withExpiry[j].expire)
+	})
+	return withExpiry
+}
+
+// RangeByExpiry calls fn for every entry with expiry set, from the
+// one expiring first. Same as for Range, fn is run outside the lock.
+// O(n*log(n))
+func (b *TypedLRUCache[K, V]) RangeByExpiry(fn func(key K, value V, expire time.Time) bool) {
+	rangeEntries(sortByExpiry(b.copyEntries(true)), fn)
+}
+
+// Keys returns all the keys, from the most recently used one. O(n)
+func (b *TypedLRUCache[K, V]) Keys() []K {
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.keysLocked()
+}
+
+// Same as Keys, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) keysLocked() []K {
+	keys := make([]K, 0, b.usedLen())
+	b.walkEntries(true, func(e *entry[K, V]) bool {
+		keys = append(keys, e.key)
+		return true
+	})
+	return keys
+}
+
+// DelFunc removes all the entries for which match returns true and
+// returns their number. Match is run with the lock held and must not
+// use the cache. O(n)
+func (b *TypedLRUCache[K, V]) DelFunc(match func(key K, value V) bool) int {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.delFuncLocked(match, &evs)
+}
+
+// Same as DelFunc, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) delFuncLocked(match func(key K, value V) bool, evs *[]evicted[K, V]) int {
+	var matched []*entry[K, V]
+	b.walkEntries(true, func(e *entry[K, V]) bool {
+		if match(e.key, e.value) {
+			matched = append(matched, e)
+		}
+		return true
+	})
+	for _, e := range matched {
+		b.evictEntry(e, EvictDeleted, evs)
+	}
+	return len(matched)
+}
+
+// Copy the entries of every shard, one shard after another. A
+// shard retired by Reshard in the middle means starting over, fn
+// must not see an entry twice.
+func (m *TypedMultiLRUCache[K, V]) copyEntries(mruFirst bool) []snapshotEntry[K, V] {
+	var entries []snapshotEntry[K, V]
+	m.eachShard(func() { entries = entries[:0] }, func(c *TypedLRUCache[K, V], evs *[]evicted[K, V]) {
+		entries = append(entries, c.copyEntriesLocked(mruFirst)...)
+	})
+	return entries
+}
+
+// Range calls fn for every entry of every shard, one shard after
+// another, from the most recently used entry of the shard. There is
+// no order between entries of different shards. Stops if fn returns
+// false. O(n)
+func (m *TypedMultiLRUCache[K, V]) Range(fn func(key K, value V, expire time.Time) bool) {
+	rangeEntries(m.copyEntries(true), fn)
+}
+
+// ReverseRange is like Range, but starts from the least recently used
+// entry of every shard. O(n)
+func (m *TypedMultiLRUCache[K, V]) ReverseRange(fn func(key K, value V, expire time.Time) bool) {
+	rangeEntries(m.copyEntries(false), fn)
+}
+
+// RangeByExpiry calls fn for every entry with expiry set, from the
+// one expiring first across all the shards. O(n*log(n))
+func (m *TypedMultiLRUCache[K, V]) RangeByExpiry(fn func(key K, value V, expire time.Time) bool) {
+	rangeEntries(sortByExpiry(m.copyEntries(true)), fn)
+}
+
+// Keys returns the keys of all the shards. O(n)
+func (m *TypedMultiLRUCache[K, V]) Keys() []K {
+	var keys []K
+	m.eachShard(func() { keys = keys[:0] }, func(c *TypedLRUCache[K, V], evs *[]evicted[K, V]) {
+		keys = append(keys, c.keysLocked()...)
+	})
+	return keys
+}
+
+func (m *TypedMultiLRUCache[K, V]) DelFunc(match func(key K, value V) bool) int {
+	var s int
+	m.eachShard(nil, func(c *TypedLRUCache[K, V], evs *[]evicted[K, V]) {
+		s += c.delFuncLocked(match, evs)
+	})
+	return s
+}
+
+// DelPrefix removes all the entries of the cache with keys starting
+// with prefix and returns their number. The cache is a
+// TypedLRUCache, TypedMultiLRUCache or TypedClockCache with string
+// keys. O(n)
+func DelPrefix[K ~string, V any](c interface {
+	DelFunc(match func(key K, value V) bool) int
+}, prefix string) int {
+	return c.DelFunc(func(key K, value V) bool {
+		return strings.HasPrefix(string(key), prefix)
+	})
+}
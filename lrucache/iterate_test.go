@@ -0,0 +1,140 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRange(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(4)
+
+	now := time.Now()
+	b.Set("a", "va", now.Add(3*time.Second))
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", now.Add(1*time.Second))
+	b.Set("d", "vd", now.Add(2*time.Second))
+	b.Get("a")
+
+	var keys []string
+	b.Range(func(key string, value interface{}, expire time.Time) bool {
+		if value != "v"+key {
+			t.Errorf("unexpected value %v for %q", value, key)
+		}
+		// Modifying the cache is fine.
+		b.Del(key)
+		keys = append(keys, key)
+		return true
+	})
+	if !reflect.DeepEqual(keys, []string{"a", "d", "c", "b"}) {
+		t.Errorf("unexpected order %v", keys)
+	}
+	if b.Len() != 0 {
+		t.Error("expecting different length")
+	}
+
+	b.Set("a", "va", now.Add(3*time.Second))
+	b.Set("b", "vb", time.Time{})
+	b.Set("c", "vc", now.Add(1*time.Second))
+	b.Set("d", "vd", now.Add(2*time.Second))
+
+	keys = nil
+	b.ReverseRange(func(key string, value interface{}, expire time.Time) bool {
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	if !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("unexpected order %v", keys)
+	}
+
+	keys = nil
+	b.RangeByExpiry(func(key string, value interface{}, expire time.Time) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if !reflect.DeepEqual(keys, []string{"c", "d", "a"}) {
+		t.Errorf("unexpected order %v", keys)
+	}
+
+	if !reflect.DeepEqual(b.Keys(), []string{"d", "c", "b", "a"}) {
+		t.Errorf("unexpected keys %v", b.Keys())
+	}
+}
+
+func TestDelPrefix(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range []Cache{NewLRUCache(100), NewMultiLRUCache(4, 100), NewClockCache(100)} {
+		for i := 0; i < 50; i++ {
+			c.Set(fmt.Sprintf("example.com/%d", i), i, time.Time{})
+			c.Set(fmt.Sprintf("example.org/%d", i), i, time.Time{})
+		}
+
+		var n int
+		switch c := c.(type) {
+		case *LRUCache:
+			n = DelPrefix(c, "example.com/")
+		case *MultiLRUCache:
+			n = DelPrefix(c, "example.com/")
+		case *ClockCache:
+			n = DelPrefix(c, "example.com/")
+		}
+		if n != 50 {
+			t.Errorf("expecting 50 entries removed, got %d", n)
+		}
+		if c.Len() != 50 {
+			t.Error("expecting different length")
+		}
+		if _, ok := c.Get("example.org/1"); !ok {
+			t.Error("expecting hit")
+		}
+	}
+
+	type host string
+	b := NewTypedLRUCache[host, int](10)
+	b.Set("example.com", 1, time.Time{})
+	b.Set("example.org", 2, time.Time{})
+	if DelPrefix(b, "example.c") != 1 || b.Len() != 1 {
+		t.Error("expecting keys of a string type to match")
+	}
+}
+
+func TestMultiLRURange(t *testing.T) {
+	t.Parallel()
+
+	m := NewTypedMultiLRUCache[int, int](4, 100)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		m.Set(i, i, now.Add(time.Duration(100-i)*time.Second))
+	}
+
+	var keys []int
+	m.Range(func(key, value int, expire time.Time) bool {
+		keys = append(keys, key)
+		return true
+	})
+	sort.Ints(keys)
+	if len(keys) != 100 || keys[0] != 0 || keys[99] != 99 {
+		t.Error("expecting all the keys")
+	}
+
+	keys = nil
+	m.RangeByExpiry(func(key, value int, expire time.Time) bool {
+		keys = append(keys, key)
+		return true
+	})
+	for i, k := range keys {
+		if k != 99-i {
+			t.Fatalf("unexpected order %v", keys)
+		}
+	}
+
+	if len(m.Keys()) != 100 {
+		t.Error("expecting all the keys")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxPerTick is the number of entries the Janitor removes from
+// every shard per tick, unless set otherwise.
+const DefaultMaxPerTick = 1000
+
+// Expirer is a cache the Janitor can clean up, implemented by
+// TypedLRUCache, TypedMultiLRUCache and TypedClockCache.
+type Expirer interface {
+	// Remove at most max entries from every shard, the ones
+	// expired before now for more than the ExpireGracePeriod.
+	expireSome(now time.Time, max int) int
+	// Current time according to the Clock of the cache.
+	now() time.Time
+}
+
+// Janitor removes expired entries from a cache in the background.
+// Every tick it removes at most MaxPerTick entries from every shard,
+// so the locks are never held for long. Entries within their
+// ExpireGracePeriod are kept. Create it with NewJanitor.
+type Janitor struct {
+	cache   Expirer
+	stop    chan struct{}
+	done    chan struct{}
+	removed atomic.Uint64
+
+	// Maximum number of entries removed from every shard per
+	// tick, DefaultMaxPerTick if zero. Must be set before Start.
+	MaxPerTick int
+	// Called from the janitor goroutine after every tick with the
+	// number of entries removed in it. Must be set before Start.
+	OnTick func(removed int)
+}
+
+// NewJanitor creates a janitor for the cache. It does nothing until
+// started.
+func NewJanitor(cache Expirer) *Janitor {
+	return &Janitor{cache: cache}
+}
+
+// Start removing expired entries every interval. Must not be called
+// again before Stop.
+func (j *Janitor) Start(interval time.Duration) {
+	j.stop = make(chan struct{})
+	j.done = make(chan struct{})
+	go j.run(interval)
+}
+
+// Stop the janitor and wait until the tick in progress is done. Does
+// nothing if the janitor is not running.
+func (j *Janitor) Stop() {
+	if j.stop == nil {
+		return
+	}
+	close(j.stop)
+	<-j.done
+	j.stop = nil
+}
+
+// Removed returns the total number of entries removed by the janitor.
+func (j *Janitor) Removed() uint64 {
+	return j.removed.Load()
+}
+
+func (j *Janitor) run(interval time.Duration) {
+	defer close(j.done)
+
+	max := j.MaxPerTick
+	if max <= 0 {
+		max = DefaultMaxPerTick
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			n := j.cache.expireSome(j.cache.now(), max)
+			j.removed.Add(uint64(n))
+			if j.OnTick != nil {
+				j.OnTick(n)
+			}
+		}
+	}
+}
+
+func (b *TypedLRUCache[K, V]) expireSome(now time.Time, max int) int {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.expireSomeLocked(now, max, &evs)
+}
+
+// Same as expireSome, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) expireSomeLocked(now time.Time, max int, evs *[]evicted[K, V]) int {
+	deadline := now.Add(-b.ExpireGracePeriod)
+	i := 0
+	for ; i < max; i++ {
+		e := b.expiredEntry(deadline)
+		if e == nil {
+			break
+		}
+		b.evictEntry(e, EvictExpired, evs)
+	}
+	return i
+}
+
+func (m *TypedMultiLRUCache[K, V]) expireSome(now time.Time, max int) int {
+	var s int
+	m.eachShard(nil, func(c *TypedLRUCache[K, V], evs *[]evicted[K, V]) {
+		s += c.expireSomeLocked(now, max, evs)
+	})
+	return s
+}
+
+func (c *TypedClockCache[K, V]) expireSome(now time.Time, max int) int {
+	var evs []evicted[K, V]
+	defer c.notifyEvicted(&evs)
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	deadline := now.Add(-c.ExpireGracePeriod)
+	i := 0
+	for ; i < max && len(c.expiryQueue) > 0 && c.expiryQueue[0].expire().Before(deadline); i++ {
+		c.evictEntry(c.expiryQueue[0], EvictExpired, &evs)
+	}
+	return i
+}
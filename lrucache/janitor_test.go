@@ -0,0 +1,99 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJanitor(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(10)
+	b.ExpireGracePeriod = time.Hour
+	past := time.Now().Add(-time.Second)
+	b.Set("a", "va", past)
+	b.Set("b", "vb", past.Add(-2*time.Hour))
+	b.Set("c", "vc", past.Add(-3*time.Hour))
+	b.Set("d", "vd", time.Time{})
+
+	ticks := make(chan int, 100)
+	j := NewJanitor(b)
+	j.MaxPerTick = 1
+	j.OnTick = func(removed int) {
+		ticks <- removed
+	}
+	j.Start(time.Millisecond)
+	for j.Removed() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	j.Stop()
+
+	if n := <-ticks; n != 1 {
+		t.Error("expecting one entry removed per tick")
+	}
+	if j.Removed() != 2 || b.Len() != 2 {
+		t.Error("expecting different number of entries removed")
+	}
+	if _, ok := b.GetQuiet("a"); !ok {
+		t.Error("expecting A to be kept during the grace period")
+	}
+	if b.Stats().Expired != 2 {
+		t.Error("expecting expired entries to be counted")
+	}
+}
+
+func TestJanitorStop(t *testing.T) {
+	t.Parallel()
+	j := NewJanitor(NewLRUCache(10))
+	// Not started.
+	j.Stop()
+
+	j.Start(time.Millisecond)
+	j.Stop()
+	j.Stop()
+	// Can be started again once stopped.
+	j.Start(time.Millisecond)
+	j.Stop()
+}
+
+func TestJanitorShards(t *testing.T) {
+	t.Parallel()
+	m := NewMultiLRUCache(4, 10)
+	now := time.Now()
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		m.Set(k, k, now.Add(-time.Second))
+	}
+
+	nonEmpty := 0
+	for _, c := range m.shards.Load().cache {
+		if c.Len() > 0 {
+			nonEmpty += 1
+		}
+	}
+	if n := m.expireSome(now, 1); n != nonEmpty {
+		t.Errorf("expecting one entry removed per shard, got %d", n)
+	}
+	m.expireSome(now, 10)
+	if m.Len() != 0 {
+		t.Error("expecting all entries to be removed")
+	}
+}
+
+func TestJanitorClock(t *testing.T) {
+	t.Parallel()
+	c := NewClockCache(10)
+	now := time.Now()
+	c.Set("a", "va", now.Add(-time.Second))
+	c.Set("b", "vb", now.Add(time.Minute))
+
+	j := NewJanitor(c)
+	j.Start(time.Millisecond)
+	for j.Removed() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	j.Stop()
+	if _, ok := c.GetQuiet("a"); ok || c.Len() != 1 {
+		t.Error("expecting A to be removed")
+	}
+}
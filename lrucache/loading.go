@@ -0,0 +1,227 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Loader fetches the value for a key missing from the cache. The
+// returned expire time is used when storing the value, zero means the
+// value never expires, same as for Set.
+type Loader[K comparable, V any] func(ctx context.Context, key K) (value V, expire time.Time, err error)
+
+// A load in progress. All callers asking for the same key wait for
+// the same call.
+type call[V any] struct {
+	done    chan struct{}
+	value   V
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+	refresh bool // background refresh, nobody waits for it
+}
+
+// TypedLoadingCache is a read-through wrapper around a cache. On a
+// miss the value is fetched using the loader and stored in the
+// cache. Concurrent misses for the same key result in a single load.
+type TypedLoadingCache[K comparable, V any] struct {
+	cache  TypedCache[K, V]
+	loader Loader[K, V]
+
+	lock      sync.Mutex
+	calls     map[K]*call[V]
+	refreshes chan struct{} // semaphore limiting background refreshes
+
+	// Cache for errors returned by the loader, optional. Errors
+	// are kept for NegativeTTL, so a failing origin isn't asked
+	// again on every lookup. Must be set before the cache is used.
+	Negative    TypedCache[K, error]
+	NegativeTTL time.Duration
+
+	// Serve expired values for up to MaxStale past their expiry,
+	// while a single background load refreshes them. If the
+	// loader keeps failing the value is served stale only until
+	// MaxStale passes, then Get loads synchronously. Only
+	// the caches of this package support it. The
+	// ExpireGracePeriod of the cache should be at least as long,
+	// otherwise stale entries get purged earlier. Zero disables
+	// serving stale values. Must be set before the cache is used.
+	MaxStale time.Duration
+	// Maximum number of background refreshes running at once,
+	// unlimited if zero. A stale hit doesn't start a refresh if
+	// the limit is reached, a later one will.
+	MaxRefreshes int
+
+	// Source of the current time, used to tell if values are
+	// stale. Should be the Clock of the cache, time.Now if nil.
+	// Must be set before the cache is used.
+	Clock Clock
+}
+
+// LoadingCache is the string keyed, interface{} valued loading cache.
+type LoadingCache = TypedLoadingCache[string, interface{}]
+
+func NewLoadingCache(cache Cache, loader Loader[string, interface{}]) *LoadingCache {
+	return NewTypedLoadingCache(cache, loader)
+}
+
+func NewTypedLoadingCache[K comparable, V any](cache TypedCache[K, V], loader Loader[K, V]) *TypedLoadingCache[K, V] {
+	return &TypedLoadingCache[K, V]{
+		cache:  cache,
+		loader: loader,
+		calls:  make(map[K]*call[V]),
+	}
+}
+
+// Get a key from the cache, make sure it's not stale. On a miss run
+// the loader, or wait for a load of the same key that is already in
+// progress. Returns ctx.Err() if the context is done before the
+// value is loaded. The load itself is cancelled only when all the
+// callers waiting for it are gone. With MaxStale set, an expired
+// value is returned as well while it is being refreshed.
+func (l *TypedLoadingCache[K, V]) Get(ctx context.Context, key K) (value V, err error) {
+	if c, ok := l.cache.(staleCache[K, V]); ok && l.MaxStale > 0 {
+		v, ok, expired := c.GetStale(key)
+		if ok && !expired {
+			return v, nil
+		}
+		if ok && l.servesStale(c, key) {
+			l.refresh(key)
+			return v, nil
+		}
+	} else if v, ok := l.cache.GetNotStale(key); ok {
+		return v, nil
+	}
+	if l.Negative != nil {
+		if err, ok := l.Negative.GetNotStale(key); ok {
+			return value, err
+		}
+	}
+
+	l.lock.Lock()
+	c := l.calls[key]
+	if c == nil {
+		// The load must not be cancelled just because the
+		// first caller went away.
+		c = l.startLoad(context.WithoutCancel(ctx), key, false)
+	}
+	c.waiters += 1
+	l.lock.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.err
+	case <-ctx.Done():
+		l.lock.Lock()
+		c.waiters -= 1
+		if c.waiters == 0 && !c.refresh {
+			// Nobody is interested anymore. Next caller
+			// will start a new load.
+			c.cancel()
+			if l.calls[key] == c {
+				delete(l.calls, key)
+			}
+		}
+		l.lock.Unlock()
+		return value, ctx.Err()
+	}
+}
+
+// Implemented by TypedLRUCache, TypedMultiLRUCache and
+// TypedClockCache, needed to serve stale values.
+type staleCache[K comparable, V any] interface {
+	GetStale(key K) (value V, ok, expired bool)
+	// Expiry time of the key, without touching it or counting
+	// the lookup.
+	expireOf(key K) (expire time.Time, ok bool)
+}
+
+// Whether the expired value of the key is within MaxStale.
+func (l *TypedLoadingCache[K, V]) servesStale(c staleCache[K, V], key K) bool {
+	expire, ok := c.expireOf(key)
+	return ok && clockNow(l.Clock).Sub(expire) <= l.MaxStale
+}
+
+// Start loading the key in the background. Must be called with the
+// lock held.
+func (l *TypedLoadingCache[K, V]) startLoad(ctx context.Context, key K, refresh bool) *call[V] {
+	var loadCtx context.Context
+	c := &call[V]{done: make(chan struct{}), refresh: refresh}
+	loadCtx, c.cancel = context.WithCancel(ctx)
+	l.calls[key] = c
+	go l.load(loadCtx, key, c)
+	return c
+}
+
+// Start a background refresh of a stale key, unless one is already
+// running or there are too many of them.
+func (l *TypedLoadingCache[K, V]) refresh(key K) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.calls[key] != nil {
+		return
+	}
+	if l.MaxRefreshes > 0 {
+		if l.refreshes == nil {
+			l.refreshes = make(chan struct{}, l.MaxRefreshes)
+		}
+		select {
+		case l.refreshes <- struct{}{}:
+		default:
+			return
+		}
+	}
+	l.startLoad(context.Background(), key, true)
+}
+
+func (l *TypedLoadingCache[K, V]) load(ctx context.Context, key K, c *call[V]) {
+	defer c.cancel()
+	// The waiters must be released even if storing the value
+	// panics.
+	defer func() {
+		l.lock.Lock()
+		if l.calls[key] == c {
+			delete(l.calls, key)
+		}
+		if c.refresh && l.MaxRefreshes > 0 {
+			<-l.refreshes
+		}
+		l.lock.Unlock()
+		close(c.done)
+	}()
+
+	var expire time.Time
+	c.value, expire, c.err = l.runLoader(ctx, key)
+	if c.err == nil {
+		l.cache.Set(key, c.value, expire)
+	} else if l.Negative != nil && ctx.Err() == nil {
+		// Don't remember errors caused by abandoning the load.
+		l.Negative.Set(key, c.err, clockNow(l.Clock).Add(l.NegativeTTL))
+	}
+}
+
+// ErrLoaderPanic is wrapped by the error returned to the callers
+// waiting for a load when the loader panics.
+var ErrLoaderPanic = errors.New("lrucache: loader panicked")
+
+// Run the loader, turning a panic into an error. The load runs in a
+// goroutine of its own, a panic would take the process down.
+func (l *TypedLoadingCache[K, V]) runLoader(ctx context.Context, key K) (value V, expire time.Time, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrLoaderPanic, r)
+		}
+	}()
+	return l.loader(ctx, key)
+}
+
+// Cache returns the underlying cache.
+func (l *TypedLoadingCache[K, V]) Cache() TypedCache[K, V] {
+	return l.cache
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadingCache(t *testing.T) {
+	t.Parallel()
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	l := NewLoadingCache(NewLRUCache(10), func(ctx context.Context, key string) (interface{}, time.Time, error) {
+		loads.Add(1)
+		<-release
+		return "v" + key, time.Now().Add(time.Minute), nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := l.Get(context.Background(), "a"); err != nil || v != "va" {
+				t.Errorf("expecting hit, got %v %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if loads.Load() != 1 {
+		t.Errorf("expecting a single load, got %d", loads.Load())
+	}
+	if v, ok := l.Cache().Get("a"); !ok || v != "va" {
+		t.Error("expecting value to be cached")
+	}
+}
+
+func TestLoadingCacheNegative(t *testing.T) {
+	t.Parallel()
+
+	errOrigin := errors.New("origin down")
+	var loads atomic.Int32
+	l := NewLoadingCache(NewLRUCache(10), func(ctx context.Context, key string) (interface{}, time.Time, error) {
+		loads.Add(1)
+		return nil, time.Time{}, errOrigin
+	})
+	l.Negative = NewTypedLRUCache[string, error](10)
+	l.NegativeTTL = time.Minute
+
+	for i := 0; i < 3; i++ {
+		if _, err := l.Get(context.Background(), "a"); err != errOrigin {
+			t.Errorf("expecting origin error, got %v", err)
+		}
+	}
+	if loads.Load() != 1 {
+		t.Errorf("expecting a single load, got %d", loads.Load())
+	}
+	if l.Cache().Len() != 0 {
+		t.Error("expecting errors not to be cached as values")
+	}
+}
+
+func TestLoadingCacheCancel(t *testing.T) {
+	t.Parallel()
+
+	cancelled := make(chan struct{})
+	l := NewLoadingCache(NewLRUCache(10), func(ctx context.Context, key string) (interface{}, time.Time, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, time.Time{}, ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Get(ctx, "a"); err != context.DeadlineExceeded {
+		t.Errorf("expecting deadline exceeded, got %v", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("expecting the load to be cancelled")
+	}
+}
+
+func TestLoadingCachePanic(t *testing.T) {
+	t.Parallel()
+
+	var loads atomic.Int32
+	l := NewLoadingCache(NewLRUCache(10), func(ctx context.Context, key string) (interface{}, time.Time, error) {
+		if loads.Add(1) == 1 {
+			panic("oops")
+		}
+		return "va", time.Time{}, nil
+	})
+
+	if _, err := l.Get(context.Background(), "a"); !errors.Is(err, ErrLoaderPanic) {
+		t.Errorf("expecting the panic to be returned, got %v", err)
+	}
+	if v, err := l.Get(context.Background(), "a"); err != nil || v != "va" {
+		t.Errorf("expecting the key to be loaded again, got %v %v", v, err)
+	}
+}
+
+func TestLoadingCacheNoExpiry(t *testing.T) {
+	t.Parallel()
+
+	caches := map[string]Cache{
+		"LRUCache":   NewLRUCache(10),
+		"ClockCache": NewClockCache(10),
+		"MultiLRU":   NewMultiLRUCache(2, 10),
+	}
+	for name, cache := range caches {
+		for _, maxStale := range []time.Duration{0, time.Minute} {
+			var loads atomic.Int32
+			l := NewLoadingCache(cache, func(ctx context.Context, key string) (interface{}, time.Time, error) {
+				loads.Add(1)
+				return "va", time.Time{}, nil
+			})
+			l.MaxStale = maxStale
+			cache.Clear()
+			for i := 0; i < 3; i++ {
+				if v, err := l.Get(context.Background(), "a"); err != nil || v != "va" {
+					t.Errorf("%s: expecting hit, got %v %v", name, v, err)
+				}
+			}
+			if loads.Load() != 1 {
+				t.Errorf("%s: expecting a value without expiry to be loaded once, got %d loads", name, loads.Load())
+			}
+		}
+	}
+}
+
+func TestLoadingCacheStale(t *testing.T) {
+	t.Parallel()
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	b := NewLRUCache(10)
+	b.ExpireGracePeriod = time.Hour
+	l := NewLoadingCache(b, func(ctx context.Context, key string) (interface{}, time.Time, error) {
+		loads.Add(1)
+		<-release
+		return "new", time.Now().Add(time.Minute), nil
+	})
+	l.MaxStale = time.Minute
+	l.MaxRefreshes = 1
+
+	b.Set("a", "old", time.Now().Add(-time.Second))
+	b.Set("b", "old", time.Now().Add(-time.Second))
+	for i := 0; i < 5; i++ {
+		if v, err := l.Get(context.Background(), "a"); err != nil || v != "old" {
+			t.Errorf("expecting stale hit, got %v %v", v, err)
+		}
+		if v, err := l.Get(context.Background(), "b"); err != nil || v != "old" {
+			t.Errorf("expecting stale hit, got %v %v", v, err)
+		}
+	}
+	if s := b.Stats(); s.StaleHits != 10 || s.Hits != 0 {
+		t.Errorf("expecting stale hits to be counted, got %+v", s)
+	}
+	close(release)
+
+	for i := 0; ; i++ {
+		if v, _, _ := b.GetStale("a"); v == "new" {
+			break
+		}
+		if i > 100 {
+			t.Fatal("expecting the value to be refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Refresh of B was skipped as only one may run at once.
+	if loads.Load() != 1 {
+		t.Errorf("expecting a single load, got %d", loads.Load())
+	}
+}
+
+func TestLoadingCacheStaleLimit(t *testing.T) {
+	t.Parallel()
+
+	errOrigin := errors.New("origin down")
+	b := NewLRUCache(10)
+	b.ExpireGracePeriod = time.Hour
+	l := NewLoadingCache(b, func(ctx context.Context, key string) (interface{}, time.Time, error) {
+		return nil, time.Time{}, errOrigin
+	})
+	l.MaxStale = time.Minute
+
+	b.Set("a", "old", time.Now().Add(-time.Second))
+	if v, err := l.Get(context.Background(), "a"); err != nil || v != "old" {
+		t.Errorf("expecting stale hit, got %v %v", v, err)
+	}
+
+	b.Set("a", "old", time.Now().Add(-2*time.Minute))
+	if _, err := l.Get(context.Background(), "a"); err != errOrigin {
+		t.Errorf("expecting origin error, got %v", err)
+	}
+}
@@ -11,37 +11,74 @@ import (
 // Every element in the cache is linked to three data structures:
 // Table map, PriorityQueue heap ordered by expiry and a LruList list
 // ordered by decreasing popularity.
-type entry struct {
-	element element     // list element. value is a pointer to this entry
-	key     string      // key is a key!
-	value   interface{} //
-	expire  time.Time   // time when the item is expired. it's okay to be stale.
-	index   int         // index for priority queue needs. -1 if entry is free
+type entry[K comparable, V any] struct {
+	element element   // list element. value is a pointer to this entry
+	key     K         // key is a key!
+	value   V         //
+	expire  time.Time // time when the item is expired. it's okay to be stale.
+	index   int       // index for priority queue needs. -1 if entry is free
+	weight  uint64    // cost of the entry given by the Weigher
+
+	idle     time.Duration // if set, expire is pushed by idle on every lookup
+	deadline time.Time     // but never past the deadline, unless it's zero
+	tags     []string      // groups the entry belongs to, see InvalidateTag
 }
 
-// LRUCache data structure. Never dereference it or copy it by
+// TypedLRUCache data structure. Never dereference it or copy it by
 // value. Always use it through a pointer.
-type LRUCache struct {
+type TypedLRUCache[K comparable, V any] struct {
 	lock          sync.Mutex
-	table         map[string]*entry // all entries in table must be in lruList
-	priorityQueue priorityQueue     // some elements from table may be in priorityQueue
-	lruList       list              // every entry is either used and resides in lruList
-	freeList      list              // or free and is linked to freeList
+	table         map[K]*entry[K, V]  // all entries in table must be in lruList
+	priorityQueue priorityQueue[K, V] // some elements from table may be in priorityQueue
+	lruList       list                // every entry is either used and resides in lruList
+	freeList      list                // or free and is linked to freeList
+	policy        policy[K, V]        // if set, used entries reside in the policy lists instead of lruList
+	weight        uint64              // total weight of the entries in lruList
+	stats         stats
+	tagIndex      map[string]map[*entry[K, V]]struct{} // used entries by tag
+	retired       bool                                 // entries moved away by TypedMultiLRUCache.Reshard
 
 	ExpireGracePeriod time.Duration // time after an expired entry is purged from cache (unless pushed out of LRU)
+
+	// Eviction policy, LRU by default. Must be set before Init.
+	Policy Policy
+
+	// Callback run when an entry is removed from the cache, called
+	// outside the lock. Must be set before the cache is used.
+	OnEvict func(key K, value V, reason EvictReason)
+
+	// Optional function returning the cost of an entry, for
+	// example the size of the value in bytes. If set, on top of
+	// the number of entries the cache is also bounded by the total
+	// cost of the entries, which is never more than MaxWeight.
+	// Must be set before the cache is used.
+	Weigher   func(key K, value V) uint64
+	MaxWeight uint64
+
+	// Source of the current time, time.Now if nil. Must be set
+	// before the cache is used.
+	Clock Clock
 }
 
+// LRUCache is the original string keyed, interface{} valued cache.
+type LRUCache = TypedLRUCache[string, interface{}]
+
 // Initialize the LRU cache instance. O(capacity)
-func (b *LRUCache) Init(capacity uint) {
-	b.table = make(map[string]*entry, capacity)
-	b.priorityQueue = make([]*entry, 0, capacity)
+func (b *TypedLRUCache[K, V]) Init(capacity uint) {
+	b.table = make(map[K]*entry[K, V], capacity)
+	b.priorityQueue = make([]*entry[K, V], 0, capacity)
 	b.lruList.Init()
 	b.freeList.Init()
 	heap.Init(&b.priorityQueue)
+	b.policy = newPolicy[K, V](b.Policy, capacity)
+	b.reserveEntries(capacity)
+}
 
-	// Reserve all the entries in one giant continous block of memory
-	arrayOfEntries := make([]entry, capacity)
-	for i := uint(0); i < capacity; i++ {
+// Reserve the entries in one giant continous block of memory and put
+// them on the free list.
+func (b *TypedLRUCache[K, V]) reserveEntries(n uint) {
+	arrayOfEntries := make([]entry[K, V], n)
+	for i := uint(0); i < n; i++ {
 		e := &arrayOfEntries[i]
 		e.element.Value = e
 		e.index = -1
@@ -51,20 +88,26 @@ func (b *LRUCache) Init(capacity uint) {
 
 // Create new LRU cache instance. Allocate all the needed memory. O(capacity)
 func NewLRUCache(capacity uint) *LRUCache {
-	b := &LRUCache{}
+	return NewTypedLRUCache[string, interface{}](capacity)
+}
+
+// Create new LRU cache instance with keys of type K and values of
+// type V. Allocate all the needed memory. O(capacity)
+func NewTypedLRUCache[K comparable, V any](capacity uint) *TypedLRUCache[K, V] {
+	b := &TypedLRUCache[K, V]{}
 	b.Init(capacity)
 	return b
 }
 
 // Give me the entry with lowest expiry field if it's before now.
-func (b *LRUCache) expiredEntry(now time.Time) *entry {
+func (b *TypedLRUCache[K, V]) expiredEntry(now time.Time) *entry[K, V] {
 	if len(b.priorityQueue) == 0 {
 		return nil
 	}
 
 	if now.IsZero() {
 		// Fill it only when actually used.
-		now = time.Now()
+		now = b.now()
 	}
 
 	if e := b.priorityQueue[0]; e.expire.Before(now) {
@@ -74,44 +117,79 @@ func (b *LRUCache) expiredEntry(now time.Time) *entry {
 }
 
 // Give me the least used entry.
-func (b *LRUCache) leastUsedEntry() *entry {
-	return b.lruList.Back().Value.(*entry)
+func (b *TypedLRUCache[K, V]) leastUsedEntry() *entry[K, V] {
+	if b.policy != nil {
+		return b.policy.victim()
+	}
+	return b.lruList.Back().Value.(*entry[K, V])
+}
+
+// Number of used entries.
+func (b *TypedLRUCache[K, V]) usedLen() int {
+	if b.policy != nil {
+		return b.policy.len()
+	}
+	return b.lruList.Len()
+}
+
+// Call fn for every used entry, from the most recently used one or
+// from the least recently used one. Stop early if fn returns false.
+func (b *TypedLRUCache[K, V]) walkEntries(mruFirst bool, fn func(e *entry[K, V]) bool) {
+	if b.policy != nil {
+		b.policy.walk(mruFirst, fn)
+		return
+	}
+	walkList(&b.lruList, mruFirst, fn)
 }
 
-func (b *LRUCache) freeSomeEntry(now time.Time) (e *entry, used bool) {
+func (b *TypedLRUCache[K, V]) freeSomeEntry(now time.Time) (e *entry[K, V], used bool, reason EvictReason) {
 	if b.freeList.Len() > 0 {
-		return b.freeList.Front().Value.(*entry), false
+		return b.freeList.Front().Value.(*entry[K, V]), false, reason
 	}
 
 	e = b.expiredEntry(now)
 	if e != nil {
-		return e, true
+		return e, true, EvictExpired
 	}
 
-	if b.lruList.Len() == 0 {
-		return nil, false
+	if b.usedLen() == 0 {
+		return nil, false, reason
 	}
 
-	return b.leastUsedEntry(), true
+	return b.leastUsedEntry(), true, EvictCapacity
 }
 
 // Move entry from used/lru list to a free list. Clear the entry as well.
-func (b *LRUCache) removeEntry(e *entry) {
-	if e.element.list != &b.lruList {
+func (b *TypedLRUCache[K, V]) removeEntry(e *entry[K, V]) {
+	if b.policy == nil && e.element.list != &b.lruList {
 		panic("list lruList")
 	}
+	if e.element.list == &b.freeList {
+		panic("list freeList")
+	}
 
 	if e.index != -1 {
 		heap.Remove(&b.priorityQueue, e.index)
 	}
-	b.lruList.Remove(&e.element)
+	if b.policy != nil {
+		b.policy.remove(e)
+	} else {
+		b.lruList.Remove(&e.element)
+	}
 	b.freeList.PushElementFront(&e.element)
 	delete(b.table, e.key)
-	e.key = ""
-	e.value = nil
+	b.weight -= e.weight
+	b.unindexTags(e)
+	var zeroKey K
+	var zeroValue V
+	e.key = zeroKey
+	e.value = zeroValue
+	e.idle = 0
+	e.deadline = time.Time{}
+	e.tags = nil
 }
 
-func (b *LRUCache) insertEntry(e *entry) {
+func (b *TypedLRUCache[K, V]) insertEntry(e *entry[K, V]) {
 	if e.element.list != &b.freeList {
 		panic("list freeList")
 	}
@@ -120,197 +198,372 @@ func (b *LRUCache) insertEntry(e *entry) {
 		heap.Push(&b.priorityQueue, e)
 	}
 	b.freeList.Remove(&e.element)
-	b.lruList.PushElementFront(&e.element)
+	if b.policy != nil {
+		b.policy.insert(e)
+	} else {
+		b.lruList.PushElementFront(&e.element)
+	}
 	b.table[e.key] = e
+	b.weight += e.weight
+	b.indexTags(e)
 }
 
-func (b *LRUCache) touchEntry(e *entry) {
+// Whether an entry expiring at expire has expired at now. Zero expire
+// means the entry never expires, all the lookups and the conditional
+// updates agree on that.
+func hasExpired(expire, now time.Time) bool {
+	return !expire.IsZero() && expire.Before(now)
+}
+
+func (b *TypedLRUCache[K, V]) touchEntry(e *entry[K, V], now time.Time) {
+	if e.idle > 0 {
+		b.slideExpire(e, now)
+	}
+	if b.policy != nil {
+		b.policy.touch(e)
+		return
+	}
 	b.lruList.MoveToFront(&e.element)
 }
 
+// Lookup of a key that is not in the cache.
+func (b *TypedLRUCache[K, V]) missEntry(key K) {
+	b.stats.misses.Add(1)
+	if b.policy != nil {
+		b.policy.miss(key)
+	}
+}
+
 // SetNow adds an item to the cache overwriting existing one if it
 // exists. Allows specifing current time required to expire an item
 // when no more slots are used. O(log(n)) if expiry is set, O(1) when
-// clear.
-func (b *LRUCache) SetNow(key string, value interface{}, expire time.Time, now time.Time) {
-	b.lock.Lock()
+// clear. With the Weigher set, an item weighting more than MaxWeight
+// is not added, but still replaces the existing one.
+func (b *TypedLRUCache[K, V]) SetNow(key K, value V, expire time.Time, now time.Time) {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
 	defer b.lock.Unlock()
 
-	var used bool
+	b.set(key, value, expire, nil, now, &evs)
+}
 
+// Add or overwrite an item. An existing entry is updated in place,
+// keeping its place in the eviction policy, but losing its tags and
+// idle timeout. Must be called with the lock held.
+func (b *TypedLRUCache[K, V]) set(key K, value V, expire time.Time, tags []string, now time.Time, evs *[]evicted[K, V]) {
 	e := b.table[key]
 	if e != nil {
-		used = true
-	} else {
-		e, used = b.freeSomeEntry(now)
-		if e == nil {
+		old := e.value
+		if b.replaceValue(e, value, now, evs) {
+			if b.OnEvict != nil {
+				*evs = append(*evs, evicted[K, V]{key, old, EvictOverwritten})
+			}
+			b.setExpire(e, expire)
+			e.idle = 0
+			e.deadline = time.Time{}
+			b.unindexTags(e)
+			e.tags = tags
+			b.indexTags(e)
+			return
+		}
+		e = nil
+	}
+
+	var weight uint64
+	if b.Weigher != nil {
+		weight = b.Weigher(key, value)
+		if !b.makeRoom(weight, nil, now, evs) {
 			return
 		}
 	}
+
+	var used bool
+	var reason EvictReason
+	e, used, reason = b.freeSomeEntry(now)
+	if e == nil {
+		return
+	}
 	if used {
-		b.removeEntry(e)
+		b.evictEntry(e, reason, evs)
 	}
 
 	e.key = key
 	e.value = value
 	e.expire = expire
+	e.weight = weight
+	e.tags = tags
 	b.insertEntry(e)
 }
 
+// Change the value of an entry in place and touch it, keeping its
+// expiry time, tags and idle timeout. If the new value doesn't fit
+// with the Weigher set, the entry is evicted with EvictOverwritten
+// and false returned. Must be called with the lock held.
+func (b *TypedLRUCache[K, V]) replaceValue(e *entry[K, V], value V, now time.Time, evs *[]evicted[K, V]) bool {
+	b.touchEntry(e, now)
+	if b.Weigher != nil {
+		weight := b.Weigher(e.key, value)
+		b.weight -= e.weight
+		e.weight = 0
+		if !b.makeRoom(weight, e, now, evs) {
+			b.evictEntry(e, EvictOverwritten, evs)
+			return false
+		}
+		e.weight = weight
+		b.weight += weight
+	}
+	e.value = value
+	return true
+}
+
 // Set adds an item to the cache overwriting existing one if it
 // exists. O(log(n)) if expiry is set, O(1) when clear.
-func (b *LRUCache) Set(key string, value interface{}, expire time.Time) {
+func (b *TypedLRUCache[K, V]) Set(key K, value V, expire time.Time) {
 	b.SetNow(key, value, expire, time.Time{})
 }
 
 // Get a key from the cache, possibly stale. Update its LRU score. O(1)
-func (b *LRUCache) Get(key string) (v interface{}, ok bool) {
-	b.lock.Lock()
+func (b *TypedLRUCache[K, V]) Get(key K) (value V, ok bool) {
+	b.acquireLock()
 	defer b.lock.Unlock()
 
+	return b.getLocked(key)
+}
+
+// Same as Get, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) getLocked(key K) (value V, ok bool) {
 	e := b.table[key]
 	if e == nil {
-		return nil, false
+		b.missEntry(key)
+		return value, false
 	}
 
-	b.touchEntry(e)
+	b.stats.hits.Add(1)
+	b.touchEntry(e, time.Time{})
 	return e.value, true
 }
 
 // GetQuiet gets a key from the cache, possibly stale. Don't modify its LRU score. O(1)
-func (b *LRUCache) GetQuiet(key string) (v interface{}, ok bool) {
-	b.lock.Lock()
+func (b *TypedLRUCache[K, V]) GetQuiet(key K) (value V, ok bool) {
+	b.acquireLock()
 	defer b.lock.Unlock()
 
+	return b.getQuietLocked(key)
+}
+
+// Same as GetQuiet, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) getQuietLocked(key K) (value V, ok bool) {
 	e := b.table[key]
 	if e == nil {
-		return nil, false
+		b.stats.misses.Add(1)
+		return value, false
 	}
 
+	b.stats.hits.Add(1)
 	return e.value, true
 }
 
 // GetNotStale gets a key from the cache, make sure it's not stale. Update its
 // LRU score. O(log(n)) if the item is expired.
-func (b *LRUCache) GetNotStale(key string) (value interface{}, ok bool) {
-	return b.GetNotStaleNow(key, time.Now())
+func (b *TypedLRUCache[K, V]) GetNotStale(key K) (value V, ok bool) {
+	return b.GetNotStaleNow(key, b.now())
 }
 
 // GetNotStaleNow gets a key from the cache, make sure it's not stale. Update its
 // LRU score. O(log(n)) if the item is expired.
-func (b *LRUCache) GetNotStaleNow(key string, now time.Time) (value interface{}, ok bool) {
-	b.lock.Lock()
+func (b *TypedLRUCache[K, V]) GetNotStaleNow(key K, now time.Time) (value V, ok bool) {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
 	defer b.lock.Unlock()
 
+	return b.getNotStaleLocked(key, now, &evs)
+}
+
+// Same as GetNotStaleNow, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) getNotStaleLocked(key K, now time.Time, evs *[]evicted[K, V]) (value V, ok bool) {
 	e := b.table[key]
 	if e == nil {
-		return nil, false
+		b.missEntry(key)
+		return value, false
 	}
 
-	if e.expire.Before(now) {
+	if hasExpired(e.expire, now) {
+		b.stats.misses.Add(1)
 		// Remove entries expired for more than a graceful period
-		if b.ExpireGracePeriod == 0 || e.expire.Sub(now) > b.ExpireGracePeriod {
-			b.removeEntry(e)
+		if b.ExpireGracePeriod == 0 || now.Sub(e.expire) > b.ExpireGracePeriod {
+			b.evictEntry(e, EvictExpired, evs)
 		}
-		return nil, false
+		return value, false
 	}
 
-	b.touchEntry(e)
+	b.stats.hits.Add(1)
+	b.touchEntry(e, now)
 	return e.value, true
 }
 
 // GetStale gets a key from the cache, possibly stale. Update its LRU
 // score. O(1) always.
-func (b *LRUCache) GetStale(key string) (value interface{}, ok, expired bool) {
-	return b.GetStaleNow(key, time.Now())
+func (b *TypedLRUCache[K, V]) GetStale(key K) (value V, ok, expired bool) {
+	return b.GetStaleNow(key, b.now())
 }
 
 // GetStaleNow gets a key from the cache, possibly stale. Update its LRU
 // score. O(1) always.
-func (b *LRUCache) GetStaleNow(key string, now time.Time) (value interface{}, ok, expired bool) {
-	b.lock.Lock()
+func (b *TypedLRUCache[K, V]) GetStaleNow(key K, now time.Time) (value V, ok, expired bool) {
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.getStaleLocked(key, now)
+}
+
+// Same as GetStaleNow, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) getStaleLocked(key K, now time.Time) (value V, ok, expired bool) {
+	e := b.table[key]
+	if e == nil {
+		b.missEntry(key)
+		return value, false, false
+	}
+
+	expired = hasExpired(e.expire, now)
+	if expired {
+		b.stats.staleHits.Add(1)
+	} else {
+		b.stats.hits.Add(1)
+	}
+	b.touchEntry(e, now)
+	return e.value, true, expired
+}
+
+func (b *TypedLRUCache[K, V]) expireOf(key K) (expire time.Time, ok bool) {
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.expireOfLocked(key)
+}
+
+func (b *TypedLRUCache[K, V]) expireOfLocked(key K) (expire time.Time, ok bool) {
+	if e := b.table[key]; e != nil {
+		return e.expire, true
+	}
+	return expire, false
+}
+
+// GetWithExpire gets a key from the cache, possibly stale, together
+// with its expiry time. Update its LRU score. O(1) always.
+func (b *TypedLRUCache[K, V]) GetWithExpire(key K) (value V, expire time.Time, ok bool) {
+	b.acquireLock()
 	defer b.lock.Unlock()
 
+	return b.getWithExpireLocked(key)
+}
+
+// Same as GetWithExpire, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) getWithExpireLocked(key K) (value V, expire time.Time, ok bool) {
 	e := b.table[key]
 	if e == nil {
-		return nil, false, false
+		b.missEntry(key)
+		return value, expire, false
 	}
 
-	b.touchEntry(e)
-	return e.value, true, e.expire.Before(now)
+	b.stats.hits.Add(1)
+	b.touchEntry(e, time.Time{})
+	return e.value, e.expire, true
 }
 
 // Del gets and remove a key from the cache. O(log(n)) if the item is using expiry, O(1) otherwise.
-func (b *LRUCache) Del(key string) (v interface{}, ok bool) {
-	b.lock.Lock()
+func (b *TypedLRUCache[K, V]) Del(key K) (value V, ok bool) {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
 	defer b.lock.Unlock()
 
+	return b.delLocked(key, &evs)
+}
+
+// Same as Del, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) delLocked(key K, evs *[]evicted[K, V]) (value V, ok bool) {
 	e := b.table[key]
 	if e == nil {
-		return nil, false
+		return value, false
 	}
 
-	value := e.value
-	b.removeEntry(e)
+	value = e.value
+	b.evictEntry(e, EvictDeleted, evs)
 	return value, true
 }
 
 // Evict all items from the cache. O(n*log(n))
-func (b *LRUCache) Clear() int {
-	b.lock.Lock()
+func (b *TypedLRUCache[K, V]) Clear() int {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
 	defer b.lock.Unlock()
 
+	return b.clearLocked(&evs)
+}
+
+// Same as Clear, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) clearLocked(evs *[]evicted[K, V]) int {
 	// First, remove entries that have expiry set
 	l := len(b.priorityQueue)
 	for i := 0; i < l; i++ {
 		// This could be reduced to O(n).
-		b.removeEntry(b.priorityQueue[0])
+		b.evictEntry(b.priorityQueue[0], EvictCleared, evs)
 	}
 
 	// Second, remove all remaining entries
-	r := b.lruList.Len()
+	r := b.usedLen()
 	for i := 0; i < r; i++ {
-		b.removeEntry(b.leastUsedEntry())
+		b.evictEntry(b.leastUsedEntry(), EvictCleared, evs)
 	}
 	return l + r
 }
 
 // Evict all the expired items. O(n*log(n))
-func (b *LRUCache) Expire() int {
-	return b.ExpireNow(time.Now())
+func (b *TypedLRUCache[K, V]) Expire() int {
+	return b.ExpireNow(b.now())
 }
 
 // Evict items that expire before `now`. O(n*log(n))
-func (b *LRUCache) ExpireNow(now time.Time) int {
-	b.lock.Lock()
+func (b *TypedLRUCache[K, V]) ExpireNow(now time.Time) int {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
 	defer b.lock.Unlock()
 
+	return b.expireLocked(now, &evs)
+}
+
+// Same as ExpireNow, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) expireLocked(now time.Time, evs *[]evicted[K, V]) int {
 	i := 0
 	for {
 		e := b.expiredEntry(now)
 		if e == nil {
 			break
 		}
-		b.removeEntry(e)
+		b.evictEntry(e, EvictExpired, evs)
 		i += 1
 	}
 	return i
 }
 
 // Number of entries used in the LRU
-func (b *LRUCache) Len() int {
+func (b *TypedLRUCache[K, V]) Len() int {
 	// yes. this stupid thing requires locking
-	b.lock.Lock()
+	b.acquireLock()
 	defer b.lock.Unlock()
 
-	return b.lruList.Len()
+	return b.usedLen()
 }
 
 // Capacity gets the total capacity of the LRU
-func (b *LRUCache) Capacity() int {
+func (b *TypedLRUCache[K, V]) Capacity() int {
 	// yes. this stupid thing requires locking
-	b.lock.Lock()
+	b.acquireLock()
 	defer b.lock.Unlock()
 
-	return b.lruList.Len() + b.freeList.Len()
+	return b.usedLen() + b.freeList.Len()
 }
@@ -269,6 +269,144 @@ func TestExtra(t *testing.T) {
 	}
 }
 
+type point struct {
+	x, y int
+}
+
+func TestTyped(t *testing.T) {
+	t.Parallel()
+	b := NewTypedLRUCache[point, int](2)
+
+	b.Set(point{1, 2}, 3, time.Time{})
+	b.Set(point{2, 3}, 5, time.Time{})
+
+	if v, ok := b.Get(point{1, 2}); !ok || v != 3 {
+		t.Error("expecting hit")
+	}
+
+	b.Set(point{3, 4}, 7, time.Time{})
+	if v, ok := b.Get(point{2, 3}); ok || v != 0 {
+		t.Error("expecting miss and zero value")
+	}
+
+	if v, ok := b.Del(point{1, 2}); !ok || v != 3 {
+		t.Error("expecting hit")
+	}
+	if b.Len() != 1 {
+		t.Error("expecting different length")
+	}
+
+	var c TypedCache[point, int] = b
+	if v, ok := c.GetQuiet(point{3, 4}); !ok || v != 7 {
+		t.Error("expecting hit")
+	}
+}
+
+func TestOnEvict(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(2)
+
+	reasons := map[string]EvictReason{}
+	b.OnEvict = func(key string, value interface{}, reason EvictReason) {
+		if value != "v"+key {
+			t.Errorf("unexpected value %v for %q", value, key)
+		}
+		reasons[key] = reason
+		// The lock must not be held here.
+		b.Len()
+	}
+
+	now := time.Now()
+	past := now.Add(time.Duration(-10 * time.Second))
+
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", past)
+	b.Set("c", "vc", time.Time{})
+	if r, ok := reasons["b"]; !ok || r != EvictExpired {
+		t.Error("expecting B to expire")
+	}
+
+	b.Set("d", "vd", time.Time{})
+	if r, ok := reasons["a"]; !ok || r != EvictCapacity {
+		t.Error("expecting A to be pushed out")
+	}
+
+	b.Set("d", "vd", time.Time{})
+	if r, ok := reasons["d"]; !ok || r != EvictOverwritten {
+		t.Error("expecting D to be overwritten")
+	}
+
+	b.Del("c")
+	if r, ok := reasons["c"]; !ok || r != EvictDeleted {
+		t.Error("expecting C to be deleted")
+	}
+
+	b.Set("e", "ve", past)
+	b.GetNotStaleNow("e", now)
+	if r, ok := reasons["e"]; !ok || r != EvictExpired {
+		t.Error("expecting E to expire")
+	}
+
+	b.Set("f", "vf", past)
+	if b.ExpireNow(now) != 1 || reasons["f"] != EvictExpired {
+		t.Error("expecting F to expire")
+	}
+
+	b.Clear()
+	if r, ok := reasons["d"]; !ok || r != EvictCleared {
+		t.Error("expecting D to be cleared")
+	}
+	if len(reasons) != 6 {
+		t.Error("expecting different number of evictions")
+	}
+}
+
+func TestGracePeriod(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(3)
+	b.ExpireGracePeriod = time.Minute
+
+	now := time.Now()
+	b.Set("a", "va", now.Add(-time.Second))
+	b.Set("b", "vb", now.Add(-2*time.Minute))
+
+	if _, ok := b.GetNotStaleNow("a", now); ok {
+		t.Error("expecting miss")
+	}
+	if _, ok := b.GetNotStaleNow("b", now); ok {
+		t.Error("expecting miss")
+	}
+	if v, expire, ok := b.GetWithExpire("a"); !ok || v != "va" || !expire.Equal(now.Add(-time.Second)) {
+		t.Error("expecting A to be kept for the grace period")
+	}
+	if _, _, ok := b.GetWithExpire("b"); ok {
+		t.Error("expecting B to be purged")
+	}
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(2)
+
+	now := time.Now()
+	past := now.Add(time.Duration(-10 * time.Second))
+
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", past)
+	b.Get("a")
+	b.GetQuiet("miss")
+	b.GetStaleNow("b", now)
+	b.GetNotStaleNow("b", now)
+	b.Set("c", "vc", time.Time{})
+	b.Set("d", "vd", time.Time{})
+
+	s := b.Stats()
+	if s.Hits != 1 || s.Misses != 2 || s.StaleHits != 1 ||
+		s.Expired != 1 || s.Evicted != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
 func randomString(l int) string {
 	bytes := make([]byte, l)
 	for i := 0; i < l; i++ {
@@ -0,0 +1,93 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+// Package lrumetrics exports lrucache statistics as prometheus
+// metrics. It lives in a separate package so that lrucache itself
+// doesn't depend on prometheus.
+package lrumetrics
+
+import (
+	"strconv"
+
+	"github.com/cloudflare/golibs/lrucache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatsGetter is implemented by LRUCache, MultiLRUCache and
+// ClockCache.
+type StatsGetter interface {
+	Stats() lrucache.Stats
+}
+
+// Implemented by MultiLRUCache. If the cache has shards the metrics
+// get reported per shard.
+type shardStatsGetter interface {
+	ShardStats() []lrucache.Stats
+}
+
+// Collector is a prometheus.Collector reporting the counters of a
+// single cache instance.
+type Collector struct {
+	cache StatsGetter
+
+	hits      *prometheus.Desc
+	misses    *prometheus.Desc
+	staleHits *prometheus.Desc
+	expired   *prometheus.Desc
+	evicted   *prometheus.Desc
+	contended *prometheus.Desc
+}
+
+// NewCollector creates a collector for the cache. Name is used as the
+// value of the "cache" label, to tell apart multiple caches
+// registered in the same process. Caches with shards get an
+// additional "shard" label.
+func NewCollector(name string, cache StatsGetter) *Collector {
+	var labels []string
+	if _, ok := cache.(shardStatsGetter); ok {
+		labels = []string{"shard"}
+	}
+	constLabels := prometheus.Labels{"cache": name}
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc("lrucache_"+metric, help, labels, constLabels)
+	}
+
+	return &Collector{
+		cache:     cache,
+		hits:      desc("hits_total", "Number of lookups that returned a value."),
+		misses:    desc("misses_total", "Number of lookups that didn't return a value."),
+		staleHits: desc("stale_hits_total", "Number of stale lookups that returned an expired value."),
+		expired:   desc("expired_total", "Number of entries removed because they expired."),
+		evicted:   desc("evicted_total", "Number of entries pushed out to make space for new ones."),
+		contended: desc("lock_contended_total", "Number of operations that had to wait for the lock."),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.staleHits
+	ch <- c.expired
+	ch <- c.evicted
+	ch <- c.contended
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	if m, ok := c.cache.(shardStatsGetter); ok {
+		for i, s := range m.ShardStats() {
+			c.collect(ch, s, strconv.Itoa(i))
+		}
+		return
+	}
+	c.collect(ch, c.cache.Stats())
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric, s lrucache.Stats, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(s.Hits), labels...)
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(s.Misses), labels...)
+	ch <- prometheus.MustNewConstMetric(c.staleHits, prometheus.CounterValue, float64(s.StaleHits), labels...)
+	ch <- prometheus.MustNewConstMetric(c.expired, prometheus.CounterValue, float64(s.Expired), labels...)
+	ch <- prometheus.MustNewConstMetric(c.evicted, prometheus.CounterValue, float64(s.Evicted), labels...)
+	ch <- prometheus.MustNewConstMetric(c.contended, prometheus.CounterValue, float64(s.Contended), labels...)
+}
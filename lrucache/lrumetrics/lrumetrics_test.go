@@ -0,0 +1,62 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrumetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudflare/golibs/lrucache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func gather(t *testing.T, c prometheus.Collector) map[string]float64 {
+	r := prometheus.NewPedanticRegistry()
+	if err := r.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			values[mf.GetName()] += m.GetCounter().GetValue()
+		}
+	}
+	return values
+}
+
+func TestCollector(t *testing.T) {
+	b := lrucache.NewLRUCache(1)
+	b.Set("a", "va", time.Time{})
+	b.Get("a")
+	b.Get("b")
+	b.Set("b", "vb", time.Time{})
+
+	v := gather(t, NewCollector("test", b))
+	if v["lrucache_hits_total"] != 1 || v["lrucache_misses_total"] != 1 ||
+		v["lrucache_evicted_total"] != 1 {
+		t.Errorf("unexpected metrics %v", v)
+	}
+	if _, ok := v["lrucache_lock_contended_total"]; !ok {
+		t.Errorf("expecting contention metric %v", v)
+	}
+}
+
+func TestCollectorShards(t *testing.T) {
+	m := lrucache.NewMultiLRUCache(4, 10)
+	past := time.Now().Add(-time.Second)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		m.Set(k, "v"+k, past)
+		m.GetStale(k)
+	}
+	m.Expire()
+
+	v := gather(t, NewCollector("test", m))
+	if v["lrucache_stale_hits_total"] != 4 || v["lrucache_expired_total"] != 4 {
+		t.Errorf("unexpected metrics %v", v)
+	}
+}
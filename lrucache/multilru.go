@@ -3,116 +3,255 @@
 package lrucache
 
 import (
-	"hash/crc32"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// MultiLRUCache data structure. Never dereference it or copy it by
+// TypedMultiLRUCache data structure. Never dereference it or copy it by
 // value. Always use it through a pointer.
-type MultiLRUCache struct {
+type TypedMultiLRUCache[K comparable, V any] struct {
+	// Replaced as a whole by Reshard, operations don't take any
+	// lock besides the one of the shard.
+	shards  atomic.Pointer[multiShards[K, V]]
+	reshard sync.Mutex // held by Reshard
+
+	// Callback run when an entry is removed from any of the
+	// shards, outside the lock. Set it before calling Init to have
+	// it propagated.
+	OnEvict func(key K, value V, reason EvictReason)
+
+	// Eviction policy of the shards. Must be set before Init.
+	Policy Policy
+
+	// Picks the shard for a key. Defaults to SipHash with a
+	// random key for strings and to maphash for other key types.
+	// Must be set before Init.
+	Hash Hasher[K]
+
+	// Source of the current time of the shards, time.Now if nil.
+	// Must be set before Init.
+	Clock Clock
+}
+
+// The shards of a TypedMultiLRUCache. Never modified once published.
+type multiShards[K comparable, V any] struct {
 	buckets uint
-	cache   []*LRUCache
+	cache   []*TypedLRUCache[K, V]
+	hash    Hasher[K]
+	retired Stats // counters of the shards dropped by Reshard
 }
 
+func (s *multiShards[K, V]) bucketNo(key K) uint {
+	return uint(s.hash(key) % uint64(s.buckets))
+}
+
+func (s *multiShards[K, V]) shard(key K) *TypedLRUCache[K, V] {
+	return s.cache[s.bucketNo(key)]
+}
+
+// Run fn on the current shards until it succeeds. Fn returns false
+// if it reached a shard that Reshard has moved the entries out of,
+// it's then run again on the new shards. It is safe, since nothing
+// is done on a retired shard, but fn must be ready to run more than
+// once: operations on many shards may have been applied to some of
+// them already.
+func (m *TypedMultiLRUCache[K, V]) do(fn func(s *multiShards[K, V]) bool) {
+	for !fn(m.shards.Load()) {
+	}
+}
+
+// Lock the shard of the key and return it. Same as for do, a shard
+// retired by Reshard before it was locked means trying again on the
+// new shards.
+func (m *TypedMultiLRUCache[K, V]) lockShard(key K) *TypedLRUCache[K, V] {
+	for {
+		c := m.shards.Load().shard(key)
+		if c.lockLive() {
+			return c
+		}
+	}
+}
+
+// Run fn on every shard with its lock held, one shard after another,
+// see do. Reset, unless nil, is called before every attempt.
+func (m *TypedMultiLRUCache[K, V]) eachShard(reset func(), fn func(c *TypedLRUCache[K, V], evs *[]evicted[K, V])) {
+	m.do(func(s *multiShards[K, V]) bool {
+		if reset != nil {
+			reset()
+		}
+		for _, c := range s.cache {
+			ok := c.withLock(func(evs *[]evicted[K, V]) {
+				fn(c, evs)
+			})
+			if !ok {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// MultiLRUCache is the original string keyed, interface{} valued
+// sharded cache.
+type MultiLRUCache = TypedMultiLRUCache[string, interface{}]
+
 // Using this constructor is almost always wrong. Use NewMultiLRUCache instead.
-func (m *MultiLRUCache) Init(buckets, bucket_capacity uint) {
-	m.buckets = buckets
-	m.cache = make([]*LRUCache, buckets)
+func (m *TypedMultiLRUCache[K, V]) Init(buckets, bucket_capacity uint) {
+	s := &multiShards[K, V]{
+		buckets: buckets,
+		cache:   make([]*TypedLRUCache[K, V], buckets),
+		hash:    m.Hash,
+	}
 	for i := uint(0); i < buckets; i++ {
-		m.cache[i] = NewLRUCache(bucket_capacity)
+		s.cache[i] = &TypedLRUCache[K, V]{Policy: m.Policy, OnEvict: m.OnEvict, Clock: m.Clock}
+		s.cache[i].Init(bucket_capacity)
+	}
+	if s.hash == nil {
+		s.hash = defaultHash[K]()
 	}
+	m.shards.Store(s)
 }
 
-// Set the stale expiry grace period for each cache in the multicache instance.
-func (m *MultiLRUCache) SetExpireGracePeriod(p time.Duration) {
-	for _, c := range m.cache {
+// Set the stale expiry grace period for each cache in the multicache
+// instance. Must be called before the cache is used.
+func (m *TypedMultiLRUCache[K, V]) SetExpireGracePeriod(p time.Duration) {
+	for _, c := range m.shards.Load().cache {
 		c.ExpireGracePeriod = p
 	}
 }
 
+// Set the eviction callback for each cache in the multicache
+// instance. Must be called before the cache is used.
+func (m *TypedMultiLRUCache[K, V]) SetOnEvict(fn func(key K, value V, reason EvictReason)) {
+	m.OnEvict = fn
+	for _, c := range m.shards.Load().cache {
+		c.OnEvict = fn
+	}
+}
+
 func NewMultiLRUCache(buckets, bucket_capacity uint) *MultiLRUCache {
-	m := &MultiLRUCache{}
+	return NewTypedMultiLRUCache[string, interface{}](buckets, bucket_capacity)
+}
+
+func NewTypedMultiLRUCache[K comparable, V any](buckets, bucket_capacity uint) *TypedMultiLRUCache[K, V] {
+	m := &TypedMultiLRUCache[K, V]{}
 	m.Init(buckets, bucket_capacity)
 	return m
 }
 
-func (m *MultiLRUCache) bucketNo(key string) uint {
-	// Arbitrary choice. Any fast hash will do.
-	return uint(crc32.ChecksumIEEE([]byte(key))) % m.buckets
+// Shard returns the number of the shard the key belongs to, the
+// index into ShardStats.
+func (m *TypedMultiLRUCache[K, V]) Shard(key K) int {
+	return int(m.shards.Load().bucketNo(key))
 }
 
-func (m *MultiLRUCache) Set(key string, value interface{}, expire time.Time) {
-	m.cache[m.bucketNo(key)].Set(key, value, expire)
+func (m *TypedMultiLRUCache[K, V]) Set(key K, value V, expire time.Time) {
+	m.SetNow(key, value, expire, time.Time{})
 }
 
-func (m *MultiLRUCache) SetNow(key string, value interface{}, expire time.Time, now time.Time) {
-	m.cache[m.bucketNo(key)].SetNow(key, value, expire, now)
+func (m *TypedMultiLRUCache[K, V]) SetNow(key K, value V, expire time.Time, now time.Time) {
+	var evs []evicted[K, V]
+	c := m.lockShard(key)
+	defer c.notifyEvicted(&evs)
+	defer c.lock.Unlock()
+
+	c.set(key, value, expire, nil, now, &evs)
 }
 
-func (m *MultiLRUCache) Get(key string) (value interface{}, ok bool) {
-	return m.cache[m.bucketNo(key)].Get(key)
+func (m *TypedMultiLRUCache[K, V]) Get(key K) (value V, ok bool) {
+	c := m.lockShard(key)
+	defer c.lock.Unlock()
+
+	return c.getLocked(key)
 }
 
-func (m *MultiLRUCache) GetQuiet(key string) (value interface{}, ok bool) {
-	return m.cache[m.bucketNo(key)].Get(key)
+func (m *TypedMultiLRUCache[K, V]) GetQuiet(key K) (value V, ok bool) {
+	c := m.lockShard(key)
+	defer c.lock.Unlock()
+
+	return c.getQuietLocked(key)
 }
 
-func (m *MultiLRUCache) GetNotStale(key string) (value interface{}, ok bool) {
-	return m.cache[m.bucketNo(key)].GetNotStale(key)
+func (m *TypedMultiLRUCache[K, V]) GetNotStale(key K) (value V, ok bool) {
+	return m.GetNotStaleNow(key, m.now())
 }
 
-func (m *MultiLRUCache) GetNotStaleNow(key string, now time.Time) (value interface{}, ok bool) {
-	return m.cache[m.bucketNo(key)].GetNotStaleNow(key, now)
+func (m *TypedMultiLRUCache[K, V]) GetNotStaleNow(key K, now time.Time) (value V, ok bool) {
+	var evs []evicted[K, V]
+	c := m.lockShard(key)
+	defer c.notifyEvicted(&evs)
+	defer c.lock.Unlock()
+
+	return c.getNotStaleLocked(key, now, &evs)
 }
 
-func (m *MultiLRUCache) GetStale(key string) (value interface{}, ok, expired bool) {
-	return m.cache[m.bucketNo(key)].GetStale(key)
+func (m *TypedMultiLRUCache[K, V]) GetStale(key K) (value V, ok, expired bool) {
+	return m.GetStaleNow(key, m.now())
 }
 
-func (m *MultiLRUCache) GetStaleNow(key string, now time.Time) (value interface{}, ok, expired bool) {
-	return m.cache[m.bucketNo(key)].GetStaleNow(key, now)
+func (m *TypedMultiLRUCache[K, V]) GetStaleNow(key K, now time.Time) (value V, ok, expired bool) {
+	c := m.lockShard(key)
+	defer c.lock.Unlock()
+
+	return c.getStaleLocked(key, now)
 }
 
-func (m *MultiLRUCache) Del(key string) (value interface{}, ok bool) {
-	return m.cache[m.bucketNo(key)].Del(key)
+func (m *TypedMultiLRUCache[K, V]) GetWithExpire(key K) (value V, expire time.Time, ok bool) {
+	c := m.lockShard(key)
+	defer c.lock.Unlock()
+
+	return c.getWithExpireLocked(key)
 }
 
-func (m *MultiLRUCache) Clear() int {
-	var s int
-	for _, c := range m.cache {
-		s += c.Clear()
-	}
-	return s
+func (m *TypedMultiLRUCache[K, V]) expireOf(key K) (expire time.Time, ok bool) {
+	c := m.lockShard(key)
+	defer c.lock.Unlock()
+
+	return c.expireOfLocked(key)
+}
+
+func (m *TypedMultiLRUCache[K, V]) Del(key K) (value V, ok bool) {
+	var evs []evicted[K, V]
+	c := m.lockShard(key)
+	defer c.notifyEvicted(&evs)
+	defer c.lock.Unlock()
+
+	return c.delLocked(key, &evs)
 }
 
-func (m *MultiLRUCache) Len() int {
+func (m *TypedMultiLRUCache[K, V]) Clear() int {
 	var s int
-	for _, c := range m.cache {
-		s += c.Len()
-	}
+	m.eachShard(nil, func(c *TypedLRUCache[K, V], evs *[]evicted[K, V]) {
+		s += c.clearLocked(evs)
+	})
 	return s
 }
 
-func (m *MultiLRUCache) Capacity() int {
+func (m *TypedMultiLRUCache[K, V]) Len() int {
 	var s int
-	for _, c := range m.cache {
-		s += c.Capacity()
-	}
+	m.eachShard(func() { s = 0 }, func(c *TypedLRUCache[K, V], evs *[]evicted[K, V]) {
+		s += c.usedLen()
+	})
 	return s
 }
 
-func (m *MultiLRUCache) Expire() int {
+func (m *TypedMultiLRUCache[K, V]) Capacity() int {
 	var s int
-	for _, c := range m.cache {
-		s += c.Expire()
-	}
+	m.eachShard(func() { s = 0 }, func(c *TypedLRUCache[K, V], evs *[]evicted[K, V]) {
+		s += c.usedLen() + c.freeList.Len()
+	})
 	return s
 }
 
-func (m *MultiLRUCache) ExpireNow(now time.Time) int {
+func (m *TypedMultiLRUCache[K, V]) Expire() int {
+	return m.ExpireNow(m.now())
+}
+
+func (m *TypedMultiLRUCache[K, V]) ExpireNow(now time.Time) int {
 	var s int
-	for _, c := range m.cache {
-		s += c.ExpireNow(now)
-	}
+	m.eachShard(nil, func(c *TypedLRUCache[K, V], evs *[]evicted[K, V]) {
+		s += c.expireLocked(now, evs)
+	})
 	return s
 }
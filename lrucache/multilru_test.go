@@ -4,6 +4,7 @@ package lrucache
 
 import (
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 )
@@ -94,6 +95,54 @@ func TestMultiLRUBasic(t *testing.T) {
 	}
 }
 
+func TestMultiLRUTyped(t *testing.T) {
+	t.Parallel()
+
+	m := NewTypedMultiLRUCache[int, string](4, 20)
+	for i := 0; i < 20; i++ {
+		m.Set(i, string(rune('a'+i)), time.Time{})
+	}
+
+	if m.Len() != 20 {
+		t.Error("expecting different length")
+	}
+
+	for i := 0; i < 20; i++ {
+		if v, ok := m.Get(i); !ok || v != string(rune('a'+i)) {
+			t.Errorf("expecting hit for %d", i)
+		}
+	}
+
+	if v, ok := m.Del(30); ok || v != "" {
+		t.Error("expecting miss and zero value")
+	}
+}
+
+func TestMultiLRUOnEvict(t *testing.T) {
+	t.Parallel()
+
+	var lock sync.Mutex
+	evicted := map[EvictReason]int{}
+	m := &MultiLRUCache{
+		OnEvict: func(key string, value interface{}, reason EvictReason) {
+			lock.Lock()
+			evicted[reason] += 1
+			lock.Unlock()
+		},
+	}
+	m.Init(2, 10)
+
+	for c := 'a'; c < 'z'; c = rune(int(c) + 1) {
+		m.Set(string(c), string([]rune{'v', c}), time.Time{})
+	}
+	l := m.Len()
+	m.Clear()
+
+	if evicted[EvictCapacity] != 25-l || evicted[EvictCleared] != l {
+		t.Error("expecting different number of evictions")
+	}
+}
+
 func filledMultiLRU(expire time.Time) *MultiLRUCache {
 	b := NewMultiLRUCache(4, 250)
 	for i := 0; i < 1000; i++ {
@@ -160,3 +209,125 @@ func BenchmarkConcurrentSetNXMultiLRU(bb *testing.B) {
 		_ = <-ch
 	}
 }
+
+func TestMultiLRUReshard(t *testing.T) {
+	t.Parallel()
+
+	evicted := 0
+	m := &TypedMultiLRUCache[int, int]{
+		OnEvict: func(key int, value int, reason EvictReason) {
+			evicted += 1
+		},
+	}
+	m.Init(2, 10)
+	m.SetExpireGracePeriod(time.Minute)
+
+	for i := 0; i < 10; i++ {
+		m.Set(i, i, time.Time{})
+	}
+	m.Set(10, 10, time.Now().Add(-time.Second))
+	m.Get(0)
+	m.Get(100)
+
+	m.Reshard(4, 10)
+	if m.Capacity() != 40 || m.Len() != 11 || evicted != 0 {
+		t.Error("expecting all the entries to be moved")
+	}
+	for i := 0; i < 10; i++ {
+		if v, ok := m.Get(i); !ok || v != i {
+			t.Error("expecting entry to survive resharding")
+		}
+	}
+	if _, ok, expired := m.GetStale(10); !ok || !expired {
+		t.Error("expecting stale entry to be kept")
+	}
+	if s := m.Stats(); s.Hits != 11 || s.Misses != 1 {
+		t.Error("expecting stats to be carried over")
+	}
+
+	if err := m.Reshard(0, 5); err != ErrNoBuckets {
+		t.Error("expecting no buckets to be rejected")
+	}
+	m.Reshard(1, 5)
+	if m.Len() != 5 || evicted != 6 {
+		t.Error("expecting entries to be evicted")
+	}
+
+	m.Resize(8)
+	for i := 20; i < 30; i++ {
+		m.Set(i, i, time.Time{})
+	}
+	if m.Capacity() != 8 || m.Len() != 8 {
+		t.Error("expecting different capacity")
+	}
+}
+
+func TestMultiLRUReshardConcurrent(t *testing.T) {
+	t.Parallel()
+
+	m := NewTypedMultiLRUCache[int, int](2, 100)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				m.Set(g*1000+i, i, time.Time{})
+				m.Get(g*1000 + i/2)
+			}
+		}(g)
+	}
+	for i := 1; i <= 8; i++ {
+		m.Reshard(uint(i), 50)
+	}
+	wg.Wait()
+	if m.Len() > m.Capacity() || m.Capacity() != 400 {
+		t.Error("expecting different capacity")
+	}
+}
+
+func TestMultiLRUReshardOnEvict(t *testing.T) {
+	t.Parallel()
+
+	// The callback uses the cache while Reshard runs.
+	var m *TypedMultiLRUCache[int, int]
+	m = &TypedMultiLRUCache[int, int]{
+		OnEvict: func(key int, value int, reason EvictReason) {
+			m.Get(key + 1)
+		},
+	}
+	m.Init(4, 10)
+	m.SetIdle(1, 1, time.Hour, time.Time{})
+	m.SetTagged(2, 2, time.Time{}, "t")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10000; i++ {
+			m.Set(i%100, i, time.Time{})
+		}
+	}()
+	for i := 1; i <= 8; i++ {
+		m.Reshard(uint(i), 10)
+	}
+	<-done
+
+	m.Reshard(2, 200)
+	m.SetIdle(1, 1, time.Hour, time.Time{})
+	m.SetTagged(2, 2, time.Time{}, "t")
+	m.Reshard(3, 200)
+	if e := m.shards.Load().shard(1).table[1]; e == nil || e.idle != time.Hour {
+		t.Error("expecting idle timeout to be kept")
+	}
+	if m.InvalidateTag("t") != 1 {
+		t.Error("expecting tags to be kept")
+	}
+}
+
+func TestMultiLRUNoAllocs(t *testing.T) {
+	m := NewTypedMultiLRUCache[int, int](4, 10)
+	m.Set(1, 1, time.Time{})
+	if n := testing.AllocsPerRun(100, func() { m.Get(1) }); n != 0 {
+		t.Errorf("expecting Get not to allocate, got %v", n)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the caches the current time. All the methods not taking
+// the time explicitly use the Clock of the cache, time.Now if it's
+// not set. The *Now variants of the methods override it.
+type Clock interface {
+	Now() time.Time
+}
+
+// FakeClock is a Clock for tests. Its time only moves when it's told
+// to. Safe for concurrent use.
+type FakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// NewFakeClock creates a FakeClock showing the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Set the time of the clock.
+func (c *FakeClock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = now
+}
+
+// Advance moves the time of the clock by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func clockNow(c Clock) time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+func (b *TypedLRUCache[K, V]) now() time.Time {
+	return clockNow(b.Clock)
+}
+
+func (m *TypedMultiLRUCache[K, V]) now() time.Time {
+	return clockNow(m.Clock)
+}
+
+func (c *TypedClockCache[K, V]) now() time.Time {
+	return clockNow(c.Clock)
+}
+
+func (c *ByteCache) now() time.Time {
+	return clockNow(c.Clock)
+}
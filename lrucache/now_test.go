@@ -0,0 +1,161 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var _ Clock = (*FakeClock)(nil)
+
+func TestFakeClock(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fc := NewFakeClock(start)
+	b := NewTypedLRUCache[string, int](3)
+	b.Clock = fc
+
+	b.Set("a", 1, start.Add(time.Second))
+	b.Set("b", 2, start.Add(time.Minute))
+	if _, ok := b.GetNotStale("a"); !ok {
+		t.Error("expecting A to be fresh")
+	}
+
+	fc.Advance(2 * time.Second)
+	if _, ok := b.GetNotStale("a"); ok {
+		t.Error("expecting A to be stale")
+	}
+	if !b.SetIfAbsent("a", 10, start.Add(time.Hour)) {
+		t.Error("expecting expired A to count as absent")
+	}
+	if b.Replace("c", 3, time.Time{}) {
+		t.Error("expecting C not to be replaced")
+	}
+
+	fc.Set(start.Add(2 * time.Minute))
+	if b.Expire() != 1 || b.Len() != 1 {
+		t.Error("expecting B to expire")
+	}
+	// The *Now variants override the clock.
+	if _, ok := b.GetNotStaleNow("a", start.Add(2*time.Hour)); ok {
+		t.Error("expecting A to be stale")
+	}
+}
+
+func TestFakeClockSliding(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fc := NewFakeClock(start)
+	b := NewLRUCache(3)
+	b.Clock = fc
+
+	b.SetIdle("a", "va", time.Minute, time.Time{})
+	for i := 0; i < 5; i++ {
+		fc.Advance(30 * time.Second)
+		if _, ok := b.GetNotStale("a"); !ok {
+			t.Fatal("expecting A to be kept alive by lookups")
+		}
+	}
+	fc.Advance(2 * time.Minute)
+	if _, ok := b.GetNotStale("a"); ok {
+		t.Error("expecting A to expire when idle")
+	}
+}
+
+func TestFakeClockShards(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fc := NewFakeClock(start)
+	m := &MultiLRUCache{Clock: fc}
+	m.Init(4, 10)
+	m.Set("a", "va", start.Add(time.Second))
+
+	m.Reshard(2, 10)
+	fc.Advance(2 * time.Second)
+	if _, ok := m.GetNotStale("a"); ok {
+		t.Error("expecting the shards to use the clock")
+	}
+
+	bc := &MultiByteCache{Clock: fc}
+	bc.Init(2, 10, 1024)
+	bc.Set([]byte("a"), []byte("va"), fc.Now().Add(time.Second))
+	if bc.Expire() != 0 {
+		t.Error("expecting A to be fresh")
+	}
+	fc.Advance(2 * time.Second)
+	if bc.Expire() != 1 {
+		t.Error("expecting A to expire")
+	}
+}
+
+func TestFakeClockClockCache(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fc := NewFakeClock(start)
+	c := NewClockCache(2)
+	c.Clock = fc
+
+	c.Set("a", "va", start.Add(time.Second))
+	c.Set("b", "vb", time.Time{})
+	fc.Advance(2 * time.Second)
+	// A expired, so it's evicted first.
+	c.Set("c", "vc", time.Time{})
+	if _, ok := c.GetQuiet("a"); ok {
+		t.Error("expecting A to be evicted")
+	}
+	if _, ok := c.GetQuiet("b"); !ok {
+		t.Error("expecting B to stay")
+	}
+}
+
+func TestFakeClockJanitor(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fc := NewFakeClock(start)
+	b := NewLRUCache(10)
+	b.Clock = fc
+	b.Set("a", "va", start.Add(time.Second))
+
+	j := NewJanitor(b)
+	j.Start(time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if j.Removed() != 0 {
+		t.Error("expecting A to be kept")
+	}
+	fc.Advance(2 * time.Second)
+	for j.Removed() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	j.Stop()
+	if b.Len() != 0 {
+		t.Error("expecting A to be removed")
+	}
+}
+
+func TestFakeClockLoading(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fc := NewFakeClock(start)
+	b := NewLRUCache(10)
+	b.Clock = fc
+	loads := 0
+	l := NewLoadingCache(b, func(ctx context.Context, key string) (interface{}, time.Time, error) {
+		loads += 1
+		return loads, fc.Now().Add(time.Second), nil
+	})
+	l.Clock = fc
+	l.MaxStale = time.Hour
+
+	ctx := context.Background()
+	l.Get(ctx, "a")
+	fc.Advance(time.Minute)
+	if v, err := l.Get(ctx, "a"); err != nil || v != 1 {
+		t.Error("expecting the stale value to be served")
+	}
+	fc.Advance(2 * time.Hour)
+	if v, err := l.Get(ctx, "a"); err != nil || v == 1 {
+		t.Error("expecting the value to be loaded again")
+	}
+}
@@ -0,0 +1,379 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+// Policy selects how the cache picks entries to evict when it's full.
+type Policy int
+
+const (
+	// Evict the least recently used entry.
+	PolicyLRU Policy = iota
+	// Segmented LRU. New entries go to a probationary segment and
+	// get promoted to a protected one on a second hit. Entries
+	// seen only once are evicted first, so a scan over many keys
+	// doesn't flush the protected segment.
+	PolicySLRU
+	// 2Q. New entries go to a FIFO queue. Keys pushed out of it
+	// are remembered for a while and if they are added again
+	// they go to the main LRU.
+	Policy2Q
+	// W-TinyLFU. New entries go to a small LRU window. When the
+	// window overflows its least recent entry is admitted to the
+	// main segmented LRU only if it has been used more often than
+	// the entry it would replace. Usage is approximated with a
+	// count-min sketch.
+	PolicyTinyLFU
+)
+
+func (p Policy) String() string {
+	switch p {
+	case PolicyLRU:
+		return "LRU"
+	case PolicySLRU:
+		return "SLRU"
+	case Policy2Q:
+		return "2Q"
+	case PolicyTinyLFU:
+		return "TinyLFU"
+	}
+	return "unknown"
+}
+
+// Eviction policy other than plain LRU. Used entries are linked to
+// the policy lists instead of the cache lruList. All methods are
+// called with the cache lock held.
+type policy[K comparable, V any] interface {
+	// Link a new entry.
+	insert(e *entry[K, V])
+	// Entry was found by a lookup.
+	touch(e *entry[K, V])
+	// Key was looked up but is not in the cache.
+	miss(key K)
+	// Entry is going to be removed to make space for another one.
+	evicted(e *entry[K, V])
+	// Unlink the entry.
+	remove(e *entry[K, V])
+	// Give me the entry to evict next, nil if there are none.
+	victim() *entry[K, V]
+	// Number of linked entries.
+	len() int
+	// Call fn for the entries, roughly from the most to the least
+	// recently used one, or the other way round. Stop early and
+	// return false if fn returns false.
+	walk(mruFirst bool, fn func(e *entry[K, V]) bool) bool
+	// Capacity of the cache has changed.
+	resize(capacity uint)
+}
+
+func newPolicy[K comparable, V any](p Policy, capacity uint) policy[K, V] {
+	switch p {
+	case PolicySLRU:
+		return newSLRU[K, V](capacity)
+	case Policy2Q:
+		return new2Q[K, V](capacity)
+	case PolicyTinyLFU:
+		return newTinyLFU[K, V](capacity)
+	}
+	return nil
+}
+
+func backEntry[K comparable, V any](l *list) *entry[K, V] {
+	if el := l.Back(); el != nil {
+		return el.Value.(*entry[K, V])
+	}
+	return nil
+}
+
+// Walk the list from the front or from the back.
+func walkList[K comparable, V any](l *list, mruFirst bool, fn func(e *entry[K, V]) bool) bool {
+	if mruFirst {
+		for el := l.Front(); el != nil; el = el.Next() {
+			if !fn(el.Value.(*entry[K, V])) {
+				return false
+			}
+		}
+	} else {
+		for el := l.Back(); el != nil; el = el.Prev() {
+			if !fn(el.Value.(*entry[K, V])) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Walk the lists one after another, in reverse order if not mruFirst.
+func walkLists[K comparable, V any](mruFirst bool, fn func(e *entry[K, V]) bool, lists ...*list) bool {
+	for i := range lists {
+		l := lists[i]
+		if !mruFirst {
+			l = lists[len(lists)-1-i]
+		}
+		if !walkList(l, mruFirst, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// Segmented LRU, also used as the main space of W-TinyLFU.
+type slru[K comparable, V any] struct {
+	probation    list
+	protected    list
+	maxProtected int
+}
+
+func newSLRU[K comparable, V any](capacity uint) *slru[K, V] {
+	p := &slru[K, V]{}
+	p.probation.Init()
+	p.protected.Init()
+	p.resize(capacity)
+	return p
+}
+
+func (p *slru[K, V]) resize(capacity uint) {
+	p.maxProtected = int(capacity * 8 / 10)
+	for p.protected.Len() > p.maxProtected {
+		el := p.protected.Back()
+		p.protected.Remove(el)
+		p.probation.PushElementFront(el)
+	}
+}
+
+func (p *slru[K, V]) insert(e *entry[K, V]) {
+	p.probation.PushElementFront(&e.element)
+}
+
+func (p *slru[K, V]) touch(e *entry[K, V]) {
+	if e.element.list == &p.protected {
+		p.protected.MoveToFront(&e.element)
+		return
+	}
+	p.probation.Remove(&e.element)
+	p.protected.PushElementFront(&e.element)
+	if p.protected.Len() > p.maxProtected {
+		// Demote, giving the entry one more chance.
+		el := p.protected.Back()
+		p.protected.Remove(el)
+		p.probation.PushElementFront(el)
+	}
+}
+
+func (p *slru[K, V]) miss(key K) {}
+
+func (p *slru[K, V]) evicted(e *entry[K, V]) {}
+
+func (p *slru[K, V]) remove(e *entry[K, V]) {
+	e.element.list.Remove(&e.element)
+}
+
+func (p *slru[K, V]) victim() *entry[K, V] {
+	if e := backEntry[K, V](&p.probation); e != nil {
+		return e
+	}
+	return backEntry[K, V](&p.protected)
+}
+
+func (p *slru[K, V]) len() int {
+	return p.probation.Len() + p.protected.Len()
+}
+
+func (p *slru[K, V]) walk(mruFirst bool, fn func(e *entry[K, V]) bool) bool {
+	return walkLists(mruFirst, fn, &p.protected, &p.probation)
+}
+
+// 2Q, the full version with the A1in FIFO, A1out ghost queue and Am
+// LRU from "2Q: A Low Overhead High Performance Buffer Management
+// Replacement Algorithm" by Johnson and Shasha.
+type twoQueue[K comparable, V any] struct {
+	in    list // A1in, recently added entries in FIFO order
+	main  list // Am, entries seen again after leaving A1in
+	maxIn int
+
+	// A1out, keys recently evicted from A1in. Fixed size ring,
+	// ghostSet counts how many times a key is in the ring.
+	ghosts   []K
+	ghostPos int
+	ghostLen int
+	ghostSet map[K]int
+}
+
+func new2Q[K comparable, V any](capacity uint) *twoQueue[K, V] {
+	p := &twoQueue[K, V]{}
+	p.in.Init()
+	p.main.Init()
+	p.resize(capacity)
+	return p
+}
+
+func (p *twoQueue[K, V]) resize(capacity uint) {
+	p.maxIn = int(capacity / 4)
+
+	ghosts := capacity / 2
+	if ghosts == 0 {
+		ghosts = 1
+	}
+	old, oldPos, oldLen := p.ghosts, p.ghostPos, p.ghostLen
+	p.ghosts = make([]K, ghosts)
+	p.ghostPos, p.ghostLen = 0, 0
+	p.ghostSet = make(map[K]int, ghosts)
+	// Keep the most recent ghosts, oldest first.
+	for i := 0; i < oldLen; i++ {
+		p.addGhost(old[(oldPos-oldLen+i+len(old))%len(old)])
+	}
+}
+
+func (p *twoQueue[K, V]) insert(e *entry[K, V]) {
+	if p.ghostSet[e.key] > 0 {
+		p.main.PushElementFront(&e.element)
+		return
+	}
+	p.in.PushElementFront(&e.element)
+}
+
+func (p *twoQueue[K, V]) touch(e *entry[K, V]) {
+	// Hits in A1in don't count, they are likely correlated.
+	if e.element.list == &p.main {
+		p.main.MoveToFront(&e.element)
+	}
+}
+
+func (p *twoQueue[K, V]) miss(key K) {}
+
+func (p *twoQueue[K, V]) evicted(e *entry[K, V]) {
+	if e.element.list == &p.in {
+		p.addGhost(e.key)
+	}
+}
+
+func (p *twoQueue[K, V]) addGhost(key K) {
+	if p.ghostLen == len(p.ghosts) {
+		old := p.ghosts[p.ghostPos]
+		if p.ghostSet[old] -= 1; p.ghostSet[old] == 0 {
+			delete(p.ghostSet, old)
+		}
+	} else {
+		p.ghostLen += 1
+	}
+	p.ghosts[p.ghostPos] = key
+	p.ghostSet[key] += 1
+	p.ghostPos = (p.ghostPos + 1) % len(p.ghosts)
+}
+
+func (p *twoQueue[K, V]) remove(e *entry[K, V]) {
+	e.element.list.Remove(&e.element)
+}
+
+func (p *twoQueue[K, V]) victim() *entry[K, V] {
+	if p.in.Len() > p.maxIn || p.main.Len() == 0 {
+		if e := backEntry[K, V](&p.in); e != nil {
+			return e
+		}
+	}
+	return backEntry[K, V](&p.main)
+}
+
+func (p *twoQueue[K, V]) len() int {
+	return p.in.Len() + p.main.Len()
+}
+
+func (p *twoQueue[K, V]) walk(mruFirst bool, fn func(e *entry[K, V]) bool) bool {
+	return walkLists(mruFirst, fn, &p.main, &p.in)
+}
+
+// W-TinyLFU from "TinyLFU: A Highly Efficient Cache Admission
+// Policy" by Einziger, Friedman and Manes. The window takes 1% of
+// the capacity, the rest is a segmented LRU.
+type tinyLFU[K comparable, V any] struct {
+	window    list
+	maxWindow int
+	main      *slru[K, V]
+	sketch    *sketch[K]
+}
+
+func newTinyLFU[K comparable, V any](capacity uint) *tinyLFU[K, V] {
+	p := &tinyLFU[K, V]{main: newSLRU[K, V](0)}
+	p.window.Init()
+	p.resize(capacity)
+	return p
+}
+
+// The frequencies are forgotten on resize, the sketch has to be
+// sized for the new capacity.
+func (p *tinyLFU[K, V]) resize(capacity uint) {
+	maxWindow := capacity / 100
+	if maxWindow == 0 {
+		maxWindow = 1
+	}
+	p.maxWindow = int(maxWindow)
+	for p.window.Len() > p.maxWindow {
+		candidate := backEntry[K, V](&p.window)
+		p.window.Remove(&candidate.element)
+		p.main.insert(candidate)
+	}
+	if capacity < maxWindow {
+		capacity = maxWindow
+	}
+	p.main.resize(capacity - maxWindow)
+	p.sketch = newSketch[K](capacity)
+}
+
+func (p *tinyLFU[K, V]) insert(e *entry[K, V]) {
+	p.sketch.increment(e.key)
+	p.window.PushElementFront(&e.element)
+	if p.window.Len() > p.maxWindow {
+		// There was a free entry, no need for admission.
+		candidate := backEntry[K, V](&p.window)
+		p.window.Remove(&candidate.element)
+		p.main.insert(candidate)
+	}
+}
+
+func (p *tinyLFU[K, V]) touch(e *entry[K, V]) {
+	p.sketch.increment(e.key)
+	if e.element.list == &p.window {
+		p.window.MoveToFront(&e.element)
+		return
+	}
+	p.main.touch(e)
+}
+
+func (p *tinyLFU[K, V]) miss(key K) {
+	p.sketch.increment(key)
+}
+
+func (p *tinyLFU[K, V]) evicted(e *entry[K, V]) {}
+
+func (p *tinyLFU[K, V]) remove(e *entry[K, V]) {
+	e.element.list.Remove(&e.element)
+}
+
+// The admission happens here. If the window is full its least
+// recent entry either replaces the main victim or is evicted itself.
+func (p *tinyLFU[K, V]) victim() *entry[K, V] {
+	victim := p.main.victim()
+	if p.window.Len() < p.maxWindow && victim != nil {
+		return victim
+	}
+	candidate := backEntry[K, V](&p.window)
+	if candidate == nil {
+		return victim
+	}
+	if victim == nil {
+		return candidate
+	}
+	if p.sketch.estimate(candidate.key) > p.sketch.estimate(victim.key) {
+		p.window.Remove(&candidate.element)
+		p.main.insert(candidate)
+		return victim
+	}
+	return candidate
+}
+
+func (p *tinyLFU[K, V]) len() int {
+	return p.window.Len() + p.main.len()
+}
+
+func (p *tinyLFU[K, V]) walk(mruFirst bool, fn func(e *entry[K, V]) bool) bool {
+	return walkLists(mruFirst, fn, &p.window, &p.main.protected, &p.main.probation)
+}
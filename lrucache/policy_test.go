@@ -0,0 +1,159 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+var allPolicies = []Policy{PolicyLRU, PolicySLRU, Policy2Q, PolicyTinyLFU}
+
+func getOrSet(b *LRUCache, key string) {
+	if _, ok := b.Get(key); !ok {
+		b.Set(key, key, time.Time{})
+	}
+}
+
+func TestPolicyScanResistance(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range allPolicies {
+		b := &LRUCache{Policy: p}
+		b.Init(100)
+
+		scan := 0
+		for round := 0; round < 20; round++ {
+			for i := 0; i < 50; i++ {
+				getOrSet(b, fmt.Sprintf("hot%d", i))
+			}
+			for i := 0; i < 20; i++ {
+				getOrSet(b, fmt.Sprintf("scan%d", scan))
+				scan += 1
+			}
+		}
+		for i := 0; i < 500; i++ {
+			getOrSet(b, fmt.Sprintf("scan%d", scan))
+			scan += 1
+		}
+
+		hot := 0
+		for i := 0; i < 50; i++ {
+			if _, ok := b.GetQuiet(fmt.Sprintf("hot%d", i)); ok {
+				hot += 1
+			}
+		}
+		if b.Len() != 100 || b.Capacity() != 100 {
+			t.Errorf("%v: expecting different length", p)
+		}
+		if p == PolicyLRU && hot != 0 {
+			t.Errorf("%v: expecting the scan to flush the cache, %d hot keys left", p, hot)
+		}
+		if p != PolicyLRU && hot < 40 {
+			t.Errorf("%v: expecting hot keys to survive the scan, %d left", p, hot)
+		}
+	}
+}
+
+func TestPolicyOverwrite(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range allPolicies[1:] {
+		b := &LRUCache{Policy: p}
+		b.Init(100)
+
+		// Hot keys are refreshed after every lookup, which must
+		// not send them back to the probation queue.
+		scan := 0
+		for round := 0; round < 20; round++ {
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("hot%d", i)
+				getOrSet(b, key)
+				b.Set(key, round, time.Time{})
+			}
+			for i := 0; i < 20; i++ {
+				getOrSet(b, fmt.Sprintf("scan%d", scan))
+				scan += 1
+			}
+		}
+		for i := 0; i < 500; i++ {
+			getOrSet(b, fmt.Sprintf("scan%d", scan))
+			scan += 1
+		}
+
+		hot := 0
+		for i := 0; i < 50; i++ {
+			if v, ok := b.GetQuiet(fmt.Sprintf("hot%d", i)); ok && v == 19 {
+				hot += 1
+			}
+		}
+		if hot < 40 {
+			t.Errorf("%v: expecting overwritten hot keys to survive the scan, %d left", p, hot)
+		}
+	}
+}
+
+func TestPolicyConsistency(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range allPolicies {
+		b := &LRUCache{Policy: p}
+		b.Init(50)
+		evictions := 0
+		b.OnEvict = func(key string, value interface{}, reason EvictReason) {
+			evictions += 1
+		}
+
+		r := rand.New(rand.NewSource(1))
+		past := time.Now().Add(-time.Second)
+		sets := 0
+		for i := 0; i < 10000; i++ {
+			key := fmt.Sprint(r.Intn(200))
+			switch r.Intn(4) {
+			case 0:
+				if _, ok := b.Get(key); !ok {
+					sets += 1
+				}
+				b.Set(key, key, time.Time{})
+			case 1:
+				if _, ok := b.GetQuiet(key); !ok {
+					sets += 1
+				}
+				b.Set(key, key, past)
+			case 2:
+				b.Get(key)
+			case 3:
+				b.Del(key)
+			}
+			if v, ok := b.GetQuiet(key); ok && v != key {
+				t.Fatalf("%v: unexpected value %v for %q", p, v, key)
+			}
+			if b.Len() > 50 {
+				t.Fatalf("%v: too many entries", p)
+			}
+		}
+		b.Expire()
+		l := b.Len()
+		if b.Clear() != l || b.Len() != 0 || b.Capacity() != 50 {
+			t.Errorf("%v: expecting different length", p)
+		}
+		if evictions == 0 {
+			t.Errorf("%v: expecting evictions", p)
+		}
+	}
+}
+
+func TestMultiLRUPolicy(t *testing.T) {
+	t.Parallel()
+
+	m := &MultiLRUCache{Policy: PolicyTinyLFU}
+	m.Init(4, 25)
+	for i := 0; i < 1000; i++ {
+		m.Set(fmt.Sprint(i), i, time.Time{})
+	}
+	if m.Len() > 100 || m.Capacity() != 100 {
+		t.Error("expecting different length")
+	}
+}
@@ -4,30 +4,30 @@
 
 package lrucache
 
-type priorityQueue []*entry
+type priorityQueue[K comparable, V any] []*entry[K, V]
 
-func (pq priorityQueue) Len() int {
+func (pq priorityQueue[K, V]) Len() int {
 	return len(pq)
 }
 
-func (pq priorityQueue) Less(i, j int) bool {
+func (pq priorityQueue[K, V]) Less(i, j int) bool {
 	return pq[i].expire.Before(pq[j].expire)
 }
 
-func (pq priorityQueue) Swap(i, j int) {
+func (pq priorityQueue[K, V]) Swap(i, j int) {
 	pq[i], pq[j] = pq[j], pq[i]
 	pq[i].index = i
 	pq[j].index = j
 }
 
-func (pq *priorityQueue) Push(e interface{}) {
+func (pq *priorityQueue[K, V]) Push(e interface{}) {
 	n := len(*pq)
-	item := e.(*entry)
+	item := e.(*entry[K, V])
 	item.index = n
 	*pq = append(*pq, item)
 }
 
-func (pq *priorityQueue) Pop() interface{} {
+func (pq *priorityQueue[K, V]) Pop() interface{} {
 	old := *pq
 	n := len(old)
 	item := old[n-1]
@@ -0,0 +1,121 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"errors"
+	"time"
+)
+
+// Resize changes the capacity of the LRU. Growing allocates a new
+// block of entries. When shrinking, free entries are dropped first,
+// then expired ones are evicted, then the least used ones. O(n) in
+// the number of removed entries.
+func (b *TypedLRUCache[K, V]) Resize(capacity uint) {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	b.resizeLocked(capacity, &evs)
+}
+
+// Same as Resize, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) resizeLocked(capacity uint, evs *[]evicted[K, V]) {
+	current := uint(b.usedLen() + b.freeList.Len())
+	if b.policy != nil {
+		b.policy.resize(capacity)
+	}
+	if capacity >= current {
+		b.reserveEntries(capacity - current)
+		return
+	}
+
+	now := b.now()
+	for n := current - capacity; n > 0; n-- {
+		if b.freeList.Len() == 0 {
+			if e := b.expiredEntry(now); e != nil {
+				b.evictEntry(e, EvictExpired, evs)
+			} else {
+				b.evictEntry(b.leastUsedEntry(), EvictCapacity, evs)
+			}
+		}
+		// The block of entries is released once all of them
+		// are dropped.
+		b.freeList.Remove(b.freeList.Front())
+	}
+}
+
+// Resize changes the capacity of every shard, see
+// TypedLRUCache.Resize.
+func (m *TypedMultiLRUCache[K, V]) Resize(bucketCapacity uint) {
+	m.eachShard(nil, func(c *TypedLRUCache[K, V], evs *[]evicted[K, V]) {
+		c.resizeLocked(bucketCapacity, evs)
+	})
+}
+
+// ErrNoBuckets is returned by Reshard when asked for no buckets.
+var ErrNoBuckets = errors.New("lrucache: no buckets")
+
+// Reshard replaces the shards with a new set of buckets, each of
+// bucketCapacity entries, and moves the entries over together with
+// their tags and idle timeouts. The order of entries within every old
+// shard is kept. Entries that don't fit are evicted with
+// EvictCapacity. Operations on the old shards wait until Reshard is
+// done and then run on the new ones, OnEvict is run after that.
+func (m *TypedMultiLRUCache[K, V]) Reshard(buckets, bucketCapacity uint) error {
+	if buckets == 0 {
+		return ErrNoBuckets
+	}
+	m.reshard.Lock()
+	defer m.reshard.Unlock()
+
+	old := m.shards.Load()
+	ms := &multiShards[K, V]{
+		buckets: buckets,
+		cache:   make([]*TypedLRUCache[K, V], buckets),
+		hash:    old.hash,
+		retired: old.retired,
+	}
+	for i := range ms.cache {
+		c := &TypedLRUCache[K, V]{Policy: m.Policy, OnEvict: m.OnEvict, Clock: m.Clock}
+		c.ExpireGracePeriod = old.cache[0].ExpireGracePeriod
+		c.Weigher = old.cache[0].Weigher
+		c.MaxWeight = old.cache[0].MaxWeight
+		c.Init(bucketCapacity)
+		ms.cache[i] = c
+	}
+
+	// The new shards aren't published yet, nobody else uses them.
+	var evs []evicted[K, V]
+	now := m.now()
+	for _, c := range old.cache {
+		c.lock.Lock()
+	}
+	for _, c := range old.cache {
+		ms.retired.add(c.Stats())
+		for _, e := range c.copyEntriesLocked(false) {
+			ms.shard(e.key).restoreEntry(&e, now, &evs)
+		}
+	}
+	m.shards.Store(ms)
+	for _, c := range old.cache {
+		c.retired = true
+		c.lock.Unlock()
+	}
+
+	for _, ev := range evs {
+		m.OnEvict(ev.key, ev.value, ev.reason)
+	}
+	return nil
+}
+
+// Add a copied entry, keeping its tags and idle timeout. Must be
+// called with the lock held.
+func (b *TypedLRUCache[K, V]) restoreEntry(se *snapshotEntry[K, V], now time.Time, evs *[]evicted[K, V]) {
+	b.set(se.key, se.value, se.expire, se.tags, now, evs)
+	if e := b.table[se.key]; e != nil && se.idle > 0 {
+		e.idle = se.idle
+		e.deadline = se.deadline
+	}
+}
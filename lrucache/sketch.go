@@ -0,0 +1,80 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"hash/maphash"
+)
+
+const sketchDepth = 4
+
+// Count-min sketch estimating how often keys are used. Counters
+// saturate at 15 and are halved every 10*capacity increments, so
+// the estimates follow the recent popularity of keys. Rows have
+// about four counters per cache entry to keep collisions rare.
+type sketch[K comparable] struct {
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	seed       maphash.Seed
+	additions  uint
+	resetAfter uint
+}
+
+func newSketch[K comparable](capacity uint) *sketch[K] {
+	width := uint64(16)
+	for width < 4*uint64(capacity) {
+		width *= 2
+	}
+	s := &sketch[K]{
+		mask:       width - 1,
+		seed:       maphash.MakeSeed(),
+		resetAfter: 10 * capacity,
+	}
+	if s.resetAfter < 10*16 {
+		s.resetAfter = 10 * 16
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// Index of the key counter in the i-th row.
+func (s *sketch[K]) index(h uint64, i int) uint64 {
+	// Double hashing, h1 + i*h2.
+	return (h + uint64(i)*(h>>32|1)) & s.mask
+}
+
+func (s *sketch[K]) increment(key K) {
+	h := maphash.Comparable(s.seed, key)
+	for i := range s.rows {
+		c := &s.rows[i][s.index(h, i)]
+		if *c < 15 {
+			*c += 1
+		}
+	}
+	s.additions += 1
+	if s.additions >= s.resetAfter {
+		s.reset()
+	}
+}
+
+func (s *sketch[K]) estimate(key K) uint8 {
+	h := maphash.Comparable(s.seed, key)
+	min := uint8(15)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(h, i)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+func (s *sketch[K]) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] /= 2
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Expiry of an entry used idle, capped by the deadline.
+func slidingExpire(now time.Time, idle time.Duration, deadline time.Time) time.Time {
+	expire := now.Add(idle)
+	if !deadline.IsZero() && deadline.Before(expire) {
+		return deadline
+	}
+	return expire
+}
+
+// Change the expiry time of an entry in place, keeping the priority
+// queue in order. Must be called with the lock held.
+func (b *TypedLRUCache[K, V]) setExpire(e *entry[K, V], expire time.Time) {
+	e.expire = expire
+	switch {
+	case e.index != -1 && expire.IsZero():
+		heap.Remove(&b.priorityQueue, e.index)
+	case e.index != -1:
+		heap.Fix(&b.priorityQueue, e.index)
+	case !expire.IsZero():
+		heap.Push(&b.priorityQueue, e)
+	}
+}
+
+// Set a new expiry time of an entry, for an entry with an idle
+// timeout the new deadline. Must be called with the lock held.
+func (b *TypedLRUCache[K, V]) resetExpire(e *entry[K, V], expire time.Time, now time.Time) {
+	if e.idle > 0 {
+		e.deadline = expire
+		b.setExpire(e, slidingExpire(now, e.idle, expire))
+		return
+	}
+	b.setExpire(e, expire)
+}
+
+// Push the expiry of an entry with an idle timeout. Entries that have
+// already expired stay expired.
+func (b *TypedLRUCache[K, V]) slideExpire(e *entry[K, V], now time.Time) {
+	if now.IsZero() {
+		now = b.now()
+	}
+	if e.expire.Before(now) {
+		return
+	}
+	b.setExpire(e, slidingExpire(now, e.idle, e.deadline))
+}
+
+// SetIdleNow adds an item that expires after not being used for idle,
+// but not later than expire, unless that's zero. Every lookup that
+// updates the LRU score of the item pushes its expiry. Overwrites the
+// existing item.
+func (b *TypedLRUCache[K, V]) SetIdleNow(key K, value V, idle time.Duration, expire time.Time, now time.Time) {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	b.setIdleLocked(key, value, idle, expire, now, &evs)
+}
+
+// Same as SetIdleNow, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) setIdleLocked(key K, value V, idle time.Duration, expire time.Time, now time.Time, evs *[]evicted[K, V]) {
+	b.set(key, value, slidingExpire(now, idle, expire), nil, now, evs)
+	if e := b.table[key]; e != nil {
+		e.idle = idle
+		e.deadline = expire
+	}
+}
+
+// SetIdle adds an item with an idle timeout, see SetIdleNow.
+func (b *TypedLRUCache[K, V]) SetIdle(key K, value V, idle time.Duration, expire time.Time) {
+	b.SetIdleNow(key, value, idle, expire, b.now())
+}
+
+// Touch sets a new expiry time of an item, without touching the
+// value. For an item with an idle timeout this is the new deadline.
+// Doesn't modify its LRU score. Returns false if the key is not in
+// the cache. O(log(n))
+func (b *TypedLRUCache[K, V]) Touch(key K, expire time.Time) bool {
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.touchLocked(key, expire)
+}
+
+// Same as Touch, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) touchLocked(key K, expire time.Time) bool {
+	e := b.table[key]
+	if e == nil {
+		return false
+	}
+	if e.idle > 0 {
+		e.deadline = expire
+		if !expire.IsZero() && expire.Before(e.expire) {
+			b.setExpire(e, expire)
+		}
+		return true
+	}
+	b.setExpire(e, expire)
+	return true
+}
+
+func (m *TypedMultiLRUCache[K, V]) SetIdleNow(key K, value V, idle time.Duration, expire time.Time, now time.Time) {
+	var evs []evicted[K, V]
+	c := m.lockShard(key)
+	defer c.notifyEvicted(&evs)
+	defer c.lock.Unlock()
+
+	c.setIdleLocked(key, value, idle, expire, now, &evs)
+}
+
+func (m *TypedMultiLRUCache[K, V]) SetIdle(key K, value V, idle time.Duration, expire time.Time) {
+	m.SetIdleNow(key, value, idle, expire, m.now())
+}
+
+func (m *TypedMultiLRUCache[K, V]) Touch(key K, expire time.Time) bool {
+	c := m.lockShard(key)
+	defer c.lock.Unlock()
+
+	return c.touchLocked(key, expire)
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingExpiry(t *testing.T) {
+	t.Parallel()
+	b := NewTypedLRUCache[string, int](10)
+	now := time.Now()
+
+	b.SetIdleNow("a", 1, time.Minute, time.Time{}, now)
+	b.SetIdleNow("b", 2, time.Minute, now.Add(90*time.Second), now)
+	b.Set("c", 3, now.Add(time.Minute))
+
+	now = now.Add(50 * time.Second)
+	for _, k := range []string{"a", "b", "c"} {
+		if _, ok := b.GetNotStaleNow(k, now); !ok {
+			t.Errorf("expecting %s not to be stale", k)
+		}
+	}
+
+	// A was used 50 seconds ago, B is capped by the deadline, C
+	// has a fixed expiry.
+	now = now.Add(50 * time.Second)
+	if _, ok := b.GetNotStaleNow("a", now); !ok {
+		t.Error("expecting A expiry to be extended")
+	}
+	if _, ok := b.GetNotStaleNow("b", now); ok {
+		t.Error("expecting B to expire at the deadline")
+	}
+	if _, ok := b.GetNotStaleNow("c", now); ok {
+		t.Error("expecting C to expire")
+	}
+
+	// Lookups of an expired item don't revive it.
+	now = now.Add(2 * time.Minute)
+	b.GetStaleNow("a", now)
+	if _, ok := b.GetNotStaleNow("a", now); ok {
+		t.Error("expecting A to expire when idle")
+	}
+}
+
+func TestSlidingQuiet(t *testing.T) {
+	t.Parallel()
+	b := NewTypedLRUCache[string, int](10)
+	now := time.Now()
+	b.SetIdleNow("a", 1, time.Minute, time.Time{}, now)
+
+	b.GetQuiet("a")
+	_, expire, _ := b.GetWithExpire("a")
+	if expire.Before(now.Add(time.Minute)) {
+		t.Error("expecting Get to extend the expiry")
+	}
+	b.Set("a", 2, time.Time{})
+	if _, expire, _ := b.GetWithExpire("a"); !expire.IsZero() {
+		t.Error("expecting Set to drop the idle timeout")
+	}
+}
+
+func TestTouch(t *testing.T) {
+	t.Parallel()
+	b := NewTypedLRUCache[string, int](10)
+	now := time.Now()
+	b.Set("a", 1, now.Add(time.Second))
+	b.Set("b", 2, now.Add(2*time.Second))
+	b.Set("c", 3, time.Time{})
+
+	if b.Touch("d", now) {
+		t.Error("expecting D not to be found")
+	}
+	if !b.Touch("a", now.Add(time.Hour)) || !b.Touch("c", now.Add(time.Second)) {
+		t.Error("expecting A and C to be touched")
+	}
+	if e := b.priorityQueue[0]; e.key != "c" {
+		t.Errorf("expecting C to expire first, got %s", e.key)
+	}
+	if n := b.ExpireNow(now.Add(time.Minute)); n != 2 || b.Len() != 1 {
+		t.Error("expecting B and C to expire")
+	}
+	b.Touch("a", time.Time{})
+	if len(b.priorityQueue) != 0 || b.ExpireNow(now.Add(2*time.Hour)) != 0 {
+		t.Error("expecting A not to expire")
+	}
+
+	b.SetIdleNow("d", 4, time.Hour, time.Time{}, now)
+	b.Touch("d", now.Add(time.Minute))
+	if _, ok := b.GetNotStaleNow("d", now.Add(2*time.Minute)); ok {
+		t.Error("expecting D to expire at the new deadline")
+	}
+}
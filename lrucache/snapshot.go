@@ -0,0 +1,457 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Codec converts keys and values to bytes and back. Used to write
+// and read cache snapshots.
+type Codec[K comparable, V any] interface {
+	EncodeKey(key K) ([]byte, error)
+	DecodeKey(data []byte) (K, error)
+	EncodeValue(value V) ([]byte, error)
+	DecodeValue(data []byte) (V, error)
+}
+
+// GobCodec encodes keys and values with encoding/gob. Concrete types
+// stored in interface{} keys or values must be registered using
+// gob.Register.
+type GobCodec[K comparable, V any] struct{}
+
+func gobEncode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (GobCodec[K, V]) EncodeKey(key K) ([]byte, error) {
+	return gobEncode(&key)
+}
+
+func (GobCodec[K, V]) DecodeKey(data []byte) (key K, err error) {
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&key)
+	return key, err
+}
+
+func (GobCodec[K, V]) EncodeValue(value V) ([]byte, error) {
+	return gobEncode(&value)
+}
+
+func (GobCodec[K, V]) DecodeValue(data []byte) (value V, err error) {
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+// ErrBadSnapshot is returned when reading data that is not a cache
+// snapshot.
+var ErrBadSnapshot = errors.New("lrucache: bad snapshot")
+
+// Every snapshot starts with the magic, followed by the number of
+// records and the records. A record is the key, the value, both
+// prefixed by their length, and the expiry time in nanoseconds since
+// the epoch, zero if not set. It's followed by the idle timeout in
+// nanoseconds and the deadline, zero if not set, and the number of
+// tags and the tags prefixed by their length. All the numbers are
+// varints. Records go from the most recently used to the least
+// recently used one. MultiLRUCache snapshot has a different magic and consists of the
+// number of shards followed by a snapshot of every shard, prefixed
+// by its length.
+const (
+	snapshotMagic      = "LRUSNAP2"
+	multiSnapshotMagic = "LRUMULT1"
+)
+
+type snapshotEntry[K comparable, V any] struct {
+	key      K
+	value    V
+	expire   time.Time
+	tags     []string
+	idle     time.Duration
+	deadline time.Time
+}
+
+// Copy the entries not expired at now, most recently used first.
+func (b *TypedLRUCache[K, V]) snapshotEntries(now time.Time) []snapshotEntry[K, V] {
+	return freshEntries(b.copyEntries(true), now)
+}
+
+// Drop the entries expired at now.
+func freshEntries[K comparable, V any](entries []snapshotEntry[K, V], now time.Time) []snapshotEntry[K, V] {
+	fresh := entries[:0]
+	for _, e := range entries {
+		if !hasExpired(e.expire, now) {
+			fresh = append(fresh, e)
+		}
+	}
+	return fresh
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+func fromUnixNano(x uint64) time.Time {
+	if x == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(x))
+}
+
+type snapshotWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (s *snapshotWriter) uvarint(x uint64) error {
+	n := binary.PutUvarint(s.buf[:], x)
+	_, err := s.w.Write(s.buf[:n])
+	return err
+}
+
+func (s *snapshotWriter) bytes(data []byte) error {
+	if err := s.uvarint(uint64(len(data))); err != nil {
+		return err
+	}
+	_, err := s.w.Write(data)
+	return err
+}
+
+// WriteSnapshot writes the entries that are not expired to w, in the
+// LRU order. The cache is locked only while the entries are copied,
+// encoding happens outside the lock.
+func (b *TypedLRUCache[K, V]) WriteSnapshot(w io.Writer, codec Codec[K, V]) error {
+	return writeSnapshotEntries(w, codec, b.snapshotEntries(b.now()))
+}
+
+func writeSnapshotEntries[K comparable, V any](w io.Writer, codec Codec[K, V], entries []snapshotEntry[K, V]) error {
+	s := &snapshotWriter{w: bufio.NewWriter(w)}
+	if _, err := s.w.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+	if err := s.uvarint(uint64(len(entries))); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		key, err := codec.EncodeKey(e.key)
+		if err != nil {
+			return err
+		}
+		value, err := codec.EncodeValue(e.value)
+		if err != nil {
+			return err
+		}
+		if err := s.bytes(key); err != nil {
+			return err
+		}
+		if err := s.bytes(value); err != nil {
+			return err
+		}
+		if err := s.uvarint(unixNano(e.expire)); err != nil {
+			return err
+		}
+		if err := s.uvarint(uint64(e.idle)); err != nil {
+			return err
+		}
+		if err := s.uvarint(unixNano(e.deadline)); err != nil {
+			return err
+		}
+		if err := s.uvarint(uint64(len(e.tags))); err != nil {
+			return err
+		}
+		for _, tag := range e.tags {
+			if err := s.bytes([]byte(tag)); err != nil {
+				return err
+			}
+		}
+	}
+	return s.w.Flush()
+}
+
+type snapshotReader struct {
+	r *bufio.Reader
+}
+
+func (s *snapshotReader) magic(magic string) error {
+	buf := make([]byte, len(magic))
+	if _, err := io.ReadFull(s.r, buf); err != nil {
+		return err
+	}
+	if string(buf) != magic {
+		return ErrBadSnapshot
+	}
+	return nil
+}
+
+func (s *snapshotReader) uvarint() (uint64, error) {
+	x, err := binary.ReadUvarint(s.r)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return x, err
+}
+
+func (s *snapshotReader) bytes() ([]byte, error) {
+	n, err := s.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	// Don't trust the length, a corrupted one could be huge.
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, s.r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Read the idle timeout, deadline and tags of a record.
+func (s *snapshotReader) extras(idle *time.Duration, deadline *time.Time, tags *[]string) error {
+	x, err := s.uvarint()
+	if err != nil {
+		return err
+	}
+	*idle = time.Duration(x)
+	if x, err = s.uvarint(); err != nil {
+		return err
+	}
+	*deadline = fromUnixNano(x)
+	n, err := s.uvarint()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		tag, err := s.bytes()
+		if err != nil {
+			return err
+		}
+		*tags = append(*tags, string(tag))
+	}
+	return nil
+}
+
+// Read the entries of a single cache snapshot, skipping the ones
+// expired at now.
+func readSnapshotEntries[K comparable, V any](s *snapshotReader, codec Codec[K, V], now time.Time) ([]snapshotEntry[K, V], error) {
+	if err := s.magic(snapshotMagic); err != nil {
+		return nil, err
+	}
+	n, err := s.uvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []snapshotEntry[K, V]
+	for i := uint64(0); i < n; i++ {
+		key, err := s.bytes()
+		if err != nil {
+			return nil, err
+		}
+		value, err := s.bytes()
+		if err != nil {
+			return nil, err
+		}
+		expire, err := s.uvarint()
+		if err != nil {
+			return nil, err
+		}
+
+		var e snapshotEntry[K, V]
+		e.expire = fromUnixNano(expire)
+		if err := s.extras(&e.idle, &e.deadline, &e.tags); err != nil {
+			return nil, err
+		}
+		if hasExpired(e.expire, now) {
+			continue
+		}
+		if e.key, err = codec.DecodeKey(key); err != nil {
+			return nil, err
+		}
+		if e.value, err = codec.DecodeValue(value); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Add the entries with their tags and idle timeouts, least recently
+// used first, so that the order is preserved.
+func (b *TypedLRUCache[K, V]) restoreEntries(entries []snapshotEntry[K, V], now time.Time) {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	b.restoreEntriesLocked(entries, now, &evs)
+}
+
+// Same as restoreEntries, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) restoreEntriesLocked(entries []snapshotEntry[K, V], now time.Time, evs *[]evicted[K, V]) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		b.restoreEntry(&entries[i], now, evs)
+	}
+}
+
+// ReadSnapshot adds the entries from a snapshot written by
+// WriteSnapshot, skipping the ones that have expired since. Existing
+// entries are kept, unless pushed out by the restored ones. If the
+// snapshot has more entries than fit in the cache, the most recently
+// used ones are kept.
+func (b *TypedLRUCache[K, V]) ReadSnapshot(r io.Reader, codec Codec[K, V]) error {
+	now := b.now()
+	entries, err := readSnapshotEntries(&snapshotReader{bufio.NewReader(r)}, codec, now)
+	if err != nil {
+		return err
+	}
+	b.restoreEntries(entries, now)
+	return nil
+}
+
+// WriteSnapshot writes the entries that are not expired to w. Shards
+// are copied one after another and encoded in parallel.
+func (m *TypedMultiLRUCache[K, V]) WriteSnapshot(w io.Writer, codec Codec[K, V]) error {
+	var shards [][]snapshotEntry[K, V]
+	m.eachShard(func() { shards = shards[:0] }, func(c *TypedLRUCache[K, V], evs *[]evicted[K, V]) {
+		shards = append(shards, c.copyEntriesLocked(true))
+	})
+
+	now := m.now()
+	bufs := make([]bytes.Buffer, len(shards))
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, entries := range shards {
+		wg.Add(1)
+		go func(i int, entries []snapshotEntry[K, V]) {
+			defer wg.Done()
+			errs[i] = writeSnapshotEntries(&bufs[i], codec, freshEntries(entries, now))
+		}(i, entries)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	s := &snapshotWriter{w: bufio.NewWriter(w)}
+	if _, err := s.w.WriteString(multiSnapshotMagic); err != nil {
+		return err
+	}
+	if err := s.uvarint(uint64(len(bufs))); err != nil {
+		return err
+	}
+	for i := range bufs {
+		if err := s.bytes(bufs[i].Bytes()); err != nil {
+			return err
+		}
+	}
+	return s.w.Flush()
+}
+
+// ReadSnapshot adds the entries from a snapshot written by
+// WriteSnapshot. The number of shards doesn't need to match, entries
+// are rehashed. Shards are decoded in parallel, then merged by
+// recency, see mergeByRecency, and restored in that order.
+func (m *TypedMultiLRUCache[K, V]) ReadSnapshot(r io.Reader, codec Codec[K, V]) error {
+	s := &snapshotReader{bufio.NewReader(r)}
+	if err := s.magic(multiSnapshotMagic); err != nil {
+		return err
+	}
+	n, err := s.uvarint()
+	if err != nil {
+		return err
+	}
+
+	// The number of shards can't be trusted to allocate the
+	// results upfront.
+	type result struct {
+		entries []snapshotEntry[K, V]
+		err     error
+	}
+	now := m.now()
+	var (
+		wg      sync.WaitGroup
+		results []*result
+	)
+	for i := uint64(0); i < n; i++ {
+		shard, err := s.bytes()
+		if err != nil {
+			wg.Wait()
+			return err
+		}
+		res := &result{}
+		results = append(results, res)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := &snapshotReader{bufio.NewReader(bytes.NewReader(shard))}
+			res.entries, res.err = readSnapshotEntries(r, codec, now)
+		}()
+	}
+	wg.Wait()
+	shards := make([][]snapshotEntry[K, V], len(results))
+	for i, res := range results {
+		if res.err != nil {
+			return res.err
+		}
+		shards[i] = res.entries
+	}
+
+	entries := mergeByRecency(shards)
+	m.do(func(ms *multiShards[K, V]) bool {
+		// Every shard is restored from its own least recently
+		// used entry.
+		split := make([][]snapshotEntry[K, V], len(ms.cache))
+		for _, e := range entries {
+			i := ms.bucketNo(e.key)
+			split[i] = append(split[i], e)
+		}
+		for i, c := range ms.cache {
+			ok := c.withLock(func(evs *[]evicted[K, V]) {
+				c.restoreEntriesLocked(split[i], now, evs)
+			})
+			if !ok {
+				return false
+			}
+		}
+		return true
+	})
+	return nil
+}
+
+// Merge the entries of the shards, most recently used first. Only the
+// order within every shard is known, but keys are spread evenly over
+// the shards, so the entry at the position i of n in its shard was
+// about as recently used as the ones at i/n of the others.
+func mergeByRecency[K comparable, V any](shards [][]snapshotEntry[K, V]) []snapshotEntry[K, V] {
+	type pos struct{ i, n, shard int }
+	var order []pos
+	for s, entries := range shards {
+		for i := range entries {
+			order = append(order, pos{i, len(entries), s})
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return order[a].i*order[b].n < order[b].i*order[a].n
+	})
+	merged := make([]snapshotEntry[K, V], len(order))
+	for j, p := range order {
+		merged[j] = shards[p.shard][p.i]
+	}
+	return merged
+}
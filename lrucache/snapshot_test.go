@@ -0,0 +1,151 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+	b := NewLRUCache(4)
+
+	future := time.Now().Add(time.Hour).Round(0)
+	b.Set("a", "va", time.Time{})
+	b.Set("b", "vb", future)
+	b.Set("c", "vc", time.Now().Add(-time.Second))
+	b.Set("d", "vd", time.Time{})
+	b.Get("a")
+
+	var buf bytes.Buffer
+	if err := b.WriteSnapshot(&buf, GobCodec[string, interface{}]{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewLRUCache(2)
+	if err := r.ReadSnapshot(bytes.NewReader(buf.Bytes()), GobCodec[string, interface{}]{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired C is skipped, least recently used B doesn't fit.
+	if r.Len() != 2 {
+		t.Error("expecting different length")
+	}
+	if v, ok := r.GetQuiet("a"); !ok || v != "va" {
+		t.Error("expecting hit")
+	}
+	if v, ok := r.GetQuiet("d"); !ok || v != "vd" {
+		t.Error("expecting hit")
+	}
+
+	r = NewLRUCache(4)
+	if err := r.ReadSnapshot(bytes.NewReader(buf.Bytes()), GobCodec[string, interface{}]{}); err != nil {
+		t.Fatal(err)
+	}
+	if v, expire, ok := r.GetWithExpire("b"); !ok || v != "vb" || !expire.Equal(future) {
+		t.Error("expecting hit with the same expiry")
+	}
+	if _, ok := r.Get("c"); ok {
+		t.Error("expecting miss")
+	}
+
+	if err := r.ReadSnapshot(bytes.NewReader([]byte("garbage!")), GobCodec[string, interface{}]{}); err != ErrBadSnapshot {
+		t.Errorf("expecting bad snapshot, got %v", err)
+	}
+	if err := r.ReadSnapshot(bytes.NewReader(buf.Bytes()[:buf.Len()-3]), GobCodec[string, interface{}]{}); err == nil {
+		t.Error("expecting error on truncated snapshot")
+	}
+}
+
+func TestMultiLRUSnapshot(t *testing.T) {
+	t.Parallel()
+
+	m := NewTypedMultiLRUCache[int, string](4, 100)
+	for i := 0; i < 200; i++ {
+		m.Set(i, fmt.Sprint(i), time.Time{})
+	}
+
+	var buf bytes.Buffer
+	if err := m.WriteSnapshot(&buf, GobCodec[int, string]{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewTypedMultiLRUCache[int, string](3, 100)
+	if err := r.ReadSnapshot(&buf, GobCodec[int, string]{}); err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 200 {
+		t.Error("expecting different length")
+	}
+	for i := 0; i < 200; i++ {
+		if v, ok := r.Get(i); !ok || v != fmt.Sprint(i) {
+			t.Errorf("expecting hit for %d", i)
+		}
+	}
+}
+
+func TestSnapshotTagsIdle(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fc := NewFakeClock(start)
+	b := NewTypedLRUCache[string, int](10)
+	b.Clock = fc
+	b.SetTagged("a", 1, time.Time{}, "zone1")
+	b.SetIdleNow("b", 2, time.Minute, start.Add(90*time.Second), start)
+
+	var buf bytes.Buffer
+	if err := b.WriteSnapshot(&buf, GobCodec[string, int]{}); err != nil {
+		t.Fatal(err)
+	}
+	r := NewTypedLRUCache[string, int](10)
+	r.Clock = fc
+	if err := r.ReadSnapshot(&buf, GobCodec[string, int]{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := r.InvalidateTag("zone1"); n != 1 {
+		t.Error("expecting the tags to be restored")
+	}
+	if _, ok := r.GetNotStaleNow("b", start.Add(50*time.Second)); !ok {
+		t.Error("expecting B not to be stale")
+	}
+	if _, ok := r.GetNotStaleNow("b", start.Add(80*time.Second)); !ok {
+		t.Error("expecting the idle timeout to be restored")
+	}
+	if _, ok := r.GetNotStaleNow("b", start.Add(100*time.Second)); ok {
+		t.Error("expecting the deadline to be restored")
+	}
+}
+
+func TestMultiLRUSnapshotOrder(t *testing.T) {
+	t.Parallel()
+	modulo := func(key int) uint64 {
+		return uint64(key)
+	}
+
+	m := &TypedMultiLRUCache[int, string]{Hash: modulo}
+	m.Init(4, 100)
+	for i := 0; i < 200; i++ {
+		m.Set(i, fmt.Sprint(i), time.Time{})
+	}
+	var buf bytes.Buffer
+	if err := m.WriteSnapshot(&buf, GobCodec[int, string]{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only half of the entries fit, the most recently used ones
+	// must be kept whatever shard they come from.
+	r := &TypedMultiLRUCache[int, string]{Hash: modulo}
+	r.Init(2, 50)
+	if err := r.ReadSnapshot(&buf, GobCodec[int, string]{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if _, ok := r.GetQuiet(i); ok != (i >= 100) {
+			t.Errorf("expecting %d to be kept: %v", i, i >= 100)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Hits      uint64 // lookups that returned a value
+	Misses    uint64 // lookups that found nothing, or only a stale entry in GetNotStale
+	StaleHits uint64 // GetStale lookups that returned an expired value
+	Expired   uint64 // entries removed because they expired
+	Evicted   uint64 // entries pushed out of the LRU to make space for new ones
+	Contended uint64 // operations that had to wait for the lock
+}
+
+func (s *Stats) add(o Stats) {
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.StaleHits += o.StaleHits
+	s.Expired += o.Expired
+	s.Evicted += o.Evicted
+	s.Contended += o.Contended
+}
+
+// Counters are updated under the cache lock, but read without it.
+type stats struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	staleHits atomic.Uint64
+	expired   atomic.Uint64
+	evicted   atomic.Uint64
+	contended atomic.Uint64
+}
+
+// Lookup counters of ClockCache. Lookups don't take any lock, so the
+// counters are spread over a slot per P, each in its own cache line,
+// not to have all the CPUs write to the same one.
+type readStats struct {
+	slots []readSlot
+}
+
+type readSlot struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	staleHits atomic.Uint64
+	_         [64 - 3*8]byte
+}
+
+func newReadStats() readStats {
+	return readStats{slots: make([]readSlot, runtime.GOMAXPROCS(0))}
+}
+
+// Pick a slot at random, the random source of the runtime is per
+// thread and doesn't contend.
+func (r *readStats) slot() *readSlot {
+	return &r.slots[rand.N(len(r.slots))]
+}
+
+func (r *readStats) addTo(s *Stats) {
+	for i := range r.slots {
+		sl := &r.slots[i]
+		s.Hits += sl.hits.Load()
+		s.Misses += sl.misses.Load()
+		s.StaleHits += sl.staleHits.Load()
+	}
+}
+
+func (s *stats) countEviction(reason EvictReason) {
+	switch reason {
+	case EvictExpired:
+		s.expired.Add(1)
+	case EvictCapacity:
+		s.evicted.Add(1)
+	}
+}
+
+// Take the cache lock, counting the times it was held by someone
+// else.
+func (b *TypedLRUCache[K, V]) acquireLock() {
+	if !b.lock.TryLock() {
+		b.stats.contended.Add(1)
+		b.lock.Lock()
+	}
+}
+
+// Take the lock, unless the cache is a shard retired by
+// TypedMultiLRUCache.Reshard: the operation must be run on the new
+// shards then. Returns false without the lock held in that case.
+func (b *TypedLRUCache[K, V]) lockLive() bool {
+	b.acquireLock()
+	if b.retired {
+		b.lock.Unlock()
+		return false
+	}
+	return true
+}
+
+// Run fn with the lock held, then the OnEvict callback for the
+// entries it removed. Returns false without running fn if the cache
+// is a retired shard, see lockLive.
+func (b *TypedLRUCache[K, V]) withLock(fn func(evs *[]evicted[K, V])) bool {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	if !b.lockLive() {
+		return false
+	}
+	defer b.lock.Unlock()
+
+	fn(&evs)
+	return true
+}
+
+func (s *stats) load() Stats {
+	return Stats{
+		Hits:      s.hits.Load(),
+		Misses:    s.misses.Load(),
+		StaleHits: s.staleHits.Load(),
+		Expired:   s.expired.Load(),
+		Evicted:   s.evicted.Load(),
+		Contended: s.contended.Load(),
+	}
+}
+
+// Stats returns the counters of the cache. Doesn't need the lock.
+func (b *TypedLRUCache[K, V]) Stats() Stats {
+	return b.stats.load()
+}
+
+// Stats returns the counters summed over all the shards, including
+// the ones replaced by Reshard.
+func (m *TypedMultiLRUCache[K, V]) Stats() Stats {
+	ms := m.shards.Load()
+	s := ms.retired
+	for _, c := range ms.cache {
+		s.add(c.Stats())
+	}
+	return s
+}
+
+// ShardStats returns the counters of every shard separately.
+func (m *TypedMultiLRUCache[K, V]) ShardStats() []Stats {
+	ms := m.shards.Load()
+	s := make([]Stats, len(ms.cache))
+	for i, c := range ms.cache {
+		s[i] = c.Stats()
+	}
+	return s
+}
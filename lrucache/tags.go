@@ -0,0 +1,102 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"time"
+)
+
+// Add a used entry to the tag index. Must be called with the lock
+// held.
+func (b *TypedLRUCache[K, V]) indexTags(e *entry[K, V]) {
+	if len(e.tags) == 0 {
+		return
+	}
+	if b.tagIndex == nil {
+		b.tagIndex = make(map[string]map[*entry[K, V]]struct{})
+	}
+	for _, tag := range e.tags {
+		entries := b.tagIndex[tag]
+		if entries == nil {
+			entries = make(map[*entry[K, V]]struct{})
+			b.tagIndex[tag] = entries
+		}
+		entries[e] = struct{}{}
+	}
+}
+
+// Remove an entry from the tag index. Must be called with the lock
+// held.
+func (b *TypedLRUCache[K, V]) unindexTags(e *entry[K, V]) {
+	for _, tag := range e.tags {
+		entries := b.tagIndex[tag]
+		delete(entries, e)
+		if len(entries) == 0 {
+			delete(b.tagIndex, tag)
+		}
+	}
+}
+
+// SetTaggedNow adds an item belonging to the given tags, overwriting
+// the existing one. All the items with a tag can be removed at once
+// with InvalidateTag.
+func (b *TypedLRUCache[K, V]) SetTaggedNow(key K, value V, expire time.Time, now time.Time, tags ...string) {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	// Don't keep the caller's slice.
+	b.set(key, value, expire, append([]string(nil), tags...), now, &evs)
+}
+
+// SetTagged adds an item belonging to the given tags, see
+// SetTaggedNow.
+func (b *TypedLRUCache[K, V]) SetTagged(key K, value V, expire time.Time, tags ...string) {
+	b.SetTaggedNow(key, value, expire, time.Time{}, tags...)
+}
+
+// InvalidateTag removes all the items with the tag and returns their
+// number. O(m) in the number of removed items.
+func (b *TypedLRUCache[K, V]) InvalidateTag(tag string) int {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.invalidateTagLocked(tag, &evs)
+}
+
+// Same as InvalidateTag, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) invalidateTagLocked(tag string, evs *[]evicted[K, V]) int {
+	entries := b.tagIndex[tag]
+	n := len(entries)
+	for e := range entries {
+		b.evictEntry(e, EvictDeleted, evs)
+	}
+	return n
+}
+
+func (m *TypedMultiLRUCache[K, V]) SetTaggedNow(key K, value V, expire time.Time, now time.Time, tags ...string) {
+	// Don't keep the caller's slice.
+	tags = append([]string(nil), tags...)
+	var evs []evicted[K, V]
+	c := m.lockShard(key)
+	defer c.notifyEvicted(&evs)
+	defer c.lock.Unlock()
+
+	c.set(key, value, expire, tags, now, &evs)
+}
+
+func (m *TypedMultiLRUCache[K, V]) SetTagged(key K, value V, expire time.Time, tags ...string) {
+	m.SetTaggedNow(key, value, expire, time.Time{}, tags...)
+}
+
+// InvalidateTag removes the items with the tag from all the shards.
+func (m *TypedMultiLRUCache[K, V]) InvalidateTag(tag string) int {
+	var s int
+	m.eachShard(nil, func(c *TypedLRUCache[K, V], evs *[]evicted[K, V]) {
+		s += c.invalidateTagLocked(tag, evs)
+	})
+	return s
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTags(t *testing.T) {
+	t.Parallel()
+	evicted := map[EvictReason]int{}
+	b := &TypedLRUCache[string, int]{
+		OnEvict: func(key string, value int, reason EvictReason) {
+			evicted[reason] += 1
+		},
+	}
+	b.Init(4)
+
+	b.SetTagged("a", 1, time.Time{}, "zone1")
+	b.SetTagged("b", 2, time.Time{}, "zone1", "zone2")
+	b.SetTagged("c", 3, time.Time{}, "zone2")
+	b.Set("d", 4, time.Time{})
+
+	if n := b.InvalidateTag("zone1"); n != 2 || b.Len() != 2 {
+		t.Error("expecting A and B to be removed")
+	}
+	if evicted[EvictDeleted] != 2 {
+		t.Error("expecting OnEvict to be called")
+	}
+	if n := b.InvalidateTag("zone1"); n != 0 {
+		t.Error("expecting nothing to be removed")
+	}
+
+	// Overwriting drops the tags, eviction removes the entry from
+	// the index.
+	b.Set("c", 3, time.Time{})
+	b.SetTagged("e", 5, time.Time{}, "zone3")
+	for i := 0; i < 4; i++ {
+		b.Set(strconv.Itoa(i), i, time.Time{})
+	}
+	if n := b.InvalidateTag("zone2") + b.InvalidateTag("zone3"); n != 0 || b.Len() != 4 {
+		t.Error("expecting the tag index to be updated")
+	}
+	if len(b.tagIndex) != 0 {
+		t.Error("expecting empty tag index")
+	}
+}
+
+func TestMultiLRUTags(t *testing.T) {
+	t.Parallel()
+	m := NewTypedMultiLRUCache[string, int](4, 100)
+	for i := 0; i < 50; i++ {
+		m.SetTagged(strconv.Itoa(i), i, time.Time{}, "zone"+strconv.Itoa(i%2))
+	}
+
+	m.Reshard(3, 100)
+	if n := m.InvalidateTag("zone0"); n != 25 || m.Len() != 25 {
+		t.Error("expecting entries to be removed from all the shards")
+	}
+	if _, ok := m.Get("1"); !ok {
+		t.Error("expecting entries with other tags to be kept")
+	}
+}
@@ -0,0 +1,413 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+// Package tiered puts an in-process lrucache in front of a Kyoto
+// Tycoon database accessed with kt.Conn. Lookups are served from the
+// local cache, misses are read through from the database, with
+// concurrent misses coalesced into bulk requests. Keys missing from
+// the database can be cached as well. Writes go to the database
+// either synchronously or in the background.
+package tiered
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/golibs/kt"
+	"github.com/cloudflare/golibs/lrucache"
+)
+
+// Backend is the database behind the local cache, implemented by
+// kt.Conn and kt.TrackedConn. GetBytes must return kt.ErrNotFound
+// for missing keys, GetBulkBytes must drop them from the map.
+type Backend interface {
+	GetBytes(ctx context.Context, key string) ([]byte, error)
+	GetBulkBytes(ctx context.Context, keys map[string][]byte) error
+}
+
+// Setter is implemented by backends that can store values, it's
+// required by Cache.Set. Zero expire means the value doesn't expire.
+type Setter interface {
+	Set(ctx context.Context, key string, value []byte, expire time.Time) error
+}
+
+var (
+	_ Backend = (*kt.Conn)(nil)
+	_ Backend = (*kt.TrackedConn)(nil)
+	_ Setter  = (*kt.Conn)(nil)
+	_ Setter  = (*kt.TrackedConn)(nil)
+)
+
+// WriteMode selects how Cache.Set updates the backend.
+type WriteMode int
+
+const (
+	// Set stores the value in the backend and, if that
+	// succeeded, in the local cache.
+	WriteThrough WriteMode = iota
+	// Set stores the value in the local cache and queues it to be
+	// stored in the backend by a background goroutine.
+	WriteBehind
+)
+
+// ErrReadOnly is returned by Set if the backend doesn't implement
+// Setter.
+var ErrReadOnly = errors.New("tiered: backend can't store values")
+
+// Local entry. Keys missing in the backend are cached with found set
+// to false.
+type item struct {
+	value []byte
+	found bool
+}
+
+// A fetch in progress. All callers missing the same key wait for the
+// same call.
+type call struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+type write struct {
+	ctx    context.Context
+	key    string
+	value  []byte
+	expire time.Time
+}
+
+// Cache is a two-tier cache. Values returned by it are shared between
+// callers and must not be modified. Create it with New.
+type Cache struct {
+	backend Backend
+	local   *lrucache.TypedMultiLRUCache[string, item]
+
+	lock       sync.Mutex
+	calls      map[string]*call
+	pending    []string        // misses waiting for the batch to be sent
+	pendingCtx context.Context // context of the first of them
+
+	writerOnce sync.Once
+	writes     chan write
+	writerDone chan struct{}
+
+	// How long values stay in the local cache, forever if zero.
+	// Values stored by Set expire in the backend after TTL too.
+	TTL time.Duration
+	// How long keys missing in the backend are remembered
+	// locally, so they are not looked up again. Zero disables
+	// negative caching.
+	NegativeTTL time.Duration
+
+	// Misses are collected for up to BatchWindow and fetched
+	// from the backend with a single get_bulk request, or sooner
+	// if MaxBatch of them pile up. Zero BatchWindow fetches every
+	// miss right away.
+	BatchWindow time.Duration
+	MaxBatch    int
+
+	// How Set updates the backend, WriteThrough by default.
+	WriteMode WriteMode
+	// Number of writes queued in the WriteBehind mode before Set
+	// blocks.
+	WriteQueue int
+	// Called from the background goroutine when a queued write
+	// fails.
+	OnWriteError func(key string, err error)
+
+	clock lrucache.Clock // see WithClock
+}
+
+// Option configures the Cache in New.
+type Option func(c *Cache)
+
+// WithClock sets the source of the current time for the TTLs, of both
+// the Cache and its local cache. Defaults to time.Now.
+func WithClock(clock lrucache.Clock) Option {
+	return func(c *Cache) {
+		c.clock = clock
+	}
+}
+
+// New creates a two-tier cache in front of the backend, the local
+// cache has the given number of shards, each of bucketCapacity
+// entries. Fields of the Cache must be set before it's used.
+func New(backend Backend, buckets, bucketCapacity uint, opts ...Option) *Cache {
+	c := &Cache{
+		backend: backend,
+		calls:   make(map[string]*call),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	// The clock of the local cache must be set before Init.
+	c.local = &lrucache.TypedMultiLRUCache[string, item]{Clock: c.clock}
+	c.local.Init(buckets, bucketCapacity)
+	return c
+}
+
+func (c *Cache) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
+
+func (c *Cache) expireAfter(ttl time.Duration) time.Time {
+	if ttl == 0 {
+		return time.Time{}
+	}
+	return c.now().Add(ttl)
+}
+
+// Look the key up in the local cache, ignoring expired entries.
+func (c *Cache) lookup(key string) (item, bool) {
+	it, expire, ok := c.local.GetWithExpire(key)
+	if !ok || (!expire.IsZero() && expire.Before(c.now())) {
+		return item{}, false
+	}
+	return it, true
+}
+
+// Get the value of the key, from the local cache or from the backend.
+// Returns kt.ErrNotFound if the key is not in the backend, or
+// ctx.Err() if the context is done before the value is fetched.
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
+	if it, ok := c.lookup(key); ok {
+		if !it.found {
+			return nil, kt.ErrNotFound
+		}
+		return it.value, nil
+	}
+
+	cl := c.join(ctx, key)
+	select {
+	case <-cl.done:
+		return cl.value, cl.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Wait for a fetch of the key in progress, or queue a new one.
+func (c *Cache) join(ctx context.Context, key string) *call {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if cl := c.calls[key]; cl != nil {
+		return cl
+	}
+	cl := &call{done: make(chan struct{})}
+	c.calls[key] = cl
+
+	// Fetches must not be cancelled just because the caller that
+	// started them went away.
+	if c.BatchWindow <= 0 {
+		go c.fetch(context.WithoutCancel(ctx), []string{key})
+		return cl
+	}
+	if len(c.pending) == 0 {
+		c.pendingCtx = context.WithoutCancel(ctx)
+		time.AfterFunc(c.BatchWindow, c.flush)
+	}
+	c.pending = append(c.pending, key)
+	if c.MaxBatch > 0 && len(c.pending) >= c.MaxBatch {
+		c.sendPending()
+	}
+	return cl
+}
+
+func (c *Cache) flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sendPending()
+}
+
+// Fetch the queued misses. Must be called with the lock held. A timer
+// of a batch sent early because it got full may flush the next batch
+// a bit earlier, that's harmless.
+func (c *Cache) sendPending() {
+	if len(c.pending) == 0 {
+		return
+	}
+	go c.fetch(c.pendingCtx, c.pending)
+	c.pending = nil
+	c.pendingCtx = nil
+}
+
+// Fetch the keys from the backend, a single one with GetBytes, more
+// of them with one GetBulkBytes. Store the results locally and wake
+// up the waiting callers.
+func (c *Cache) fetch(ctx context.Context, keys []string) {
+	values := make(map[string][]byte, len(keys))
+	var err error
+	if len(keys) == 1 {
+		var v []byte
+		v, err = c.backend.GetBytes(ctx, keys[0])
+		if err == nil {
+			values[keys[0]] = v
+		} else if err == kt.ErrNotFound {
+			err = nil
+		}
+	} else {
+		for _, key := range keys {
+			values[key] = nil
+		}
+		err = c.backend.GetBulkBytes(ctx, values)
+	}
+
+	c.lock.Lock()
+	calls := make([]*call, len(keys))
+	for i, key := range keys {
+		calls[i] = c.calls[key]
+	}
+	c.lock.Unlock()
+
+	for i, key := range keys {
+		cl := calls[i]
+		if err != nil {
+			// Errors other than a missing key are not
+			// cached.
+			cl.err = err
+		} else if v, ok := values[key]; ok {
+			cl.value = v
+			// Don't overwrite a value stored by Set in the
+			// meantime.
+			c.local.SetIfAbsent(key, item{value: v, found: true}, c.expireAfter(c.TTL))
+		} else {
+			cl.err = kt.ErrNotFound
+			if c.NegativeTTL > 0 {
+				c.local.SetIfAbsent(key, item{}, c.expireAfter(c.NegativeTTL))
+			}
+		}
+	}
+
+	// Only now, so that nobody starts fetching the keys again
+	// before they are stored.
+	c.lock.Lock()
+	for _, key := range keys {
+		delete(c.calls, key)
+	}
+	c.lock.Unlock()
+	for _, cl := range calls {
+		close(cl.done)
+	}
+}
+
+// GetMulti gets the values of the keys, from the local cache or from
+// the backend. All the misses are fetched with a single get_bulk
+// request. Keys missing in the backend are left out of the result.
+func (c *Cache) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	var lookups []string
+	for _, key := range keys {
+		if it, ok := c.lookup(key); ok {
+			if it.found {
+				values[key] = it.value
+			}
+			continue
+		}
+		lookups = append(lookups, key)
+	}
+
+	waits := make(map[string]*call, len(lookups))
+	var misses []string
+	c.lock.Lock()
+	for _, key := range lookups {
+		if waits[key] != nil {
+			continue
+		}
+		cl := c.calls[key]
+		if cl == nil {
+			cl = &call{done: make(chan struct{})}
+			c.calls[key] = cl
+			misses = append(misses, key)
+		}
+		waits[key] = cl
+	}
+	c.lock.Unlock()
+
+	if len(misses) > 0 {
+		go c.fetch(context.WithoutCancel(ctx), misses)
+	}
+	for key, cl := range waits {
+		select {
+		case <-cl.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if cl.err == nil {
+			values[key] = cl.value
+		} else if cl.err != kt.ErrNotFound {
+			return nil, cl.err
+		}
+	}
+	return values, nil
+}
+
+// Set stores the value of the key, see WriteMode. It expires after
+// TTL both locally and in the backend. The value must not be modified
+// afterwards.
+func (c *Cache) Set(ctx context.Context, key string, value []byte) error {
+	s, ok := c.backend.(Setter)
+	if !ok {
+		return ErrReadOnly
+	}
+
+	expire := c.expireAfter(c.TTL)
+	if c.WriteMode == WriteBehind {
+		c.local.Set(key, item{value: value, found: true}, expire)
+		c.writerOnce.Do(c.startWriter)
+		select {
+		case c.writes <- write{context.WithoutCancel(ctx), key, value, expire}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if err := s.Set(ctx, key, value, expire); err != nil {
+		// The local value might be out of date now.
+		c.local.Del(key)
+		return err
+	}
+	c.local.Set(key, item{value: value, found: true}, expire)
+	return nil
+}
+
+func (c *Cache) startWriter() {
+	c.writes = make(chan write, c.WriteQueue)
+	c.writerDone = make(chan struct{})
+	go func() {
+		defer close(c.writerDone)
+		s := c.backend.(Setter)
+		for w := range c.writes {
+			err := s.Set(w.ctx, w.key, w.value, w.expire)
+			if err != nil && c.OnWriteError != nil {
+				c.OnWriteError(w.key, err)
+			}
+		}
+	}()
+}
+
+// Close waits until the writes queued in the WriteBehind mode are
+// done. Set must not be called afterwards.
+func (c *Cache) Close() {
+	c.writerOnce.Do(func() {})
+	if c.writes != nil {
+		close(c.writes)
+		<-c.writerDone
+	}
+}
+
+// Invalidate drops the key from the local cache, the next Get fetches
+// it from the backend again.
+func (c *Cache) Invalidate(key string) {
+	c.local.Del(key)
+}
+
+// Stats returns the counters of the local cache.
+func (c *Cache) Stats() lrucache.Stats {
+	return c.local.Stats()
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package tiered
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/golibs/kt"
+	"github.com/cloudflare/golibs/lrucache"
+)
+
+type fakeBackend struct {
+	lock    sync.Mutex
+	data    map[string][]byte
+	expires map[string]time.Time
+	gets    int
+	bulks   int
+	sets    int
+	err     error
+	delay   time.Duration
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{data: make(map[string][]byte), expires: make(map[string]time.Time)}
+}
+
+func (f *fakeBackend) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	time.Sleep(f.delay)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.gets += 1
+	if f.err != nil {
+		return nil, f.err
+	}
+	v, ok := f.data[key]
+	if !ok {
+		return nil, kt.ErrNotFound
+	}
+	return v, nil
+}
+
+func (f *fakeBackend) GetBulkBytes(ctx context.Context, keys map[string][]byte) error {
+	time.Sleep(f.delay)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.bulks += 1
+	if f.err != nil {
+		return f.err
+	}
+	for k := range keys {
+		if v, ok := f.data[k]; ok {
+			keys[k] = v
+		} else {
+			delete(keys, k)
+		}
+	}
+	return nil
+}
+
+func (f *fakeBackend) Set(ctx context.Context, key string, value []byte, expire time.Time) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.sets += 1
+	if f.err != nil {
+		return f.err
+	}
+	f.data[key] = value
+	f.expires[key] = expire
+	return nil
+}
+
+func (f *fakeBackend) counts() (gets, bulks, sets int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.gets, f.bulks, f.sets
+}
+
+func TestReadThrough(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeBackend()
+	f.data["a"] = []byte("va")
+	c := New(f, 2, 10)
+	c.NegativeTTL = time.Minute
+
+	for i := 0; i < 2; i++ {
+		if v, err := c.Get(ctx, "a"); err != nil || string(v) != "va" {
+			t.Errorf("unexpected value %q %v", v, err)
+		}
+		if _, err := c.Get(ctx, "b"); err != kt.ErrNotFound {
+			t.Errorf("expecting not found, got %v", err)
+		}
+	}
+	if gets, _, _ := f.counts(); gets != 2 {
+		t.Errorf("expecting the backend to be asked once per key, got %d", gets)
+	}
+
+	c.Invalidate("a")
+	c.Get(ctx, "a")
+	if gets, _, _ := f.counts(); gets != 3 {
+		t.Error("expecting invalidated key to be fetched again")
+	}
+
+	f.err = errors.New("backend down")
+	c.Invalidate("a")
+	if _, err := c.Get(ctx, "a"); err != f.err {
+		t.Errorf("expecting backend error, got %v", err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeBackend()
+	f.data["a"] = []byte("va")
+	clock := lrucache.NewFakeClock(time.Unix(1000, 0))
+	c := New(f, 1, 10, WithClock(clock))
+	c.TTL = time.Minute
+
+	c.Get(ctx, "a")
+	c.Get(ctx, "a")
+	clock.Advance(2 * time.Minute)
+	c.Get(ctx, "a")
+	c.Get(ctx, "b")
+	c.Get(ctx, "b")
+	if gets, _, _ := f.counts(); gets != 4 {
+		t.Errorf("expecting expired and missing keys to be fetched again, got %d", gets)
+	}
+
+	c.Set(ctx, "c", []byte("vc"))
+	if want := clock.Now().Add(time.Minute); !f.expires["c"].Equal(want) {
+		t.Errorf("expecting the value to expire in the backend at %v, got %v", want, f.expires["c"])
+	}
+	c.WriteMode = WriteBehind
+	c.Set(ctx, "d", []byte("vd"))
+	c.Close()
+	if want := clock.Now().Add(time.Minute); !f.expires["d"].Equal(want) {
+		t.Errorf("expecting the queued value to expire in the backend at %v, got %v", want, f.expires["d"])
+	}
+}
+
+// A fetch that finishes after Set must not overwrite the value, the
+// local cache has to see it's not expired with the same clock.
+func TestFetchAfterSet(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeBackend()
+	clock := lrucache.NewFakeClock(time.Unix(1000, 0))
+	c := New(f, 1, 10, WithClock(clock))
+	c.TTL = time.Minute
+
+	c.Set(ctx, "a", []byte("new"))
+	f.data["a"] = []byte("old")
+	c.calls["a"] = &call{done: make(chan struct{})}
+	c.fetch(ctx, []string{"a"})
+	if v, err := c.Get(ctx, "a"); string(v) != "new" {
+		t.Errorf("expecting the value stored by Set, got %q %v", v, err)
+	}
+
+	clock.Advance(2 * time.Minute)
+	c.calls["a"] = &call{done: make(chan struct{})}
+	c.fetch(ctx, []string{"a"})
+	if v, err := c.Get(ctx, "a"); string(v) != "old" {
+		t.Errorf("expecting the expired value to be replaced, got %q %v", v, err)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeBackend()
+	for i := 0; i < 10; i++ {
+		f.data[strconv.Itoa(i)] = []byte(strconv.Itoa(i))
+	}
+	f.delay = 10 * time.Millisecond
+	c := New(f, 4, 100)
+	c.BatchWindow = 10 * time.Millisecond
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		for i := 0; i < 11; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				v, err := c.Get(ctx, strconv.Itoa(i))
+				if i == 10 && err != kt.ErrNotFound {
+					t.Errorf("expecting not found, got %v", err)
+				} else if i < 10 && string(v) != strconv.Itoa(i) {
+					t.Errorf("unexpected value %q %v", v, err)
+				}
+			}(i)
+		}
+	}
+	wg.Wait()
+	if gets, bulks, _ := f.counts(); gets != 0 || bulks != 1 {
+		t.Errorf("expecting a single bulk request, got %d gets and %d bulks", gets, bulks)
+	}
+}
+
+func TestMaxBatch(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeBackend()
+	c := New(f, 4, 100)
+	c.BatchWindow = time.Hour
+	c.MaxBatch = 2
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Get(ctx, strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+	if _, bulks, _ := f.counts(); bulks != 2 {
+		t.Errorf("expecting full batches to be sent, got %d", bulks)
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeBackend()
+	f.data["a"] = []byte("va")
+	f.data["b"] = []byte("vb")
+	c := New(f, 2, 10)
+	c.NegativeTTL = time.Minute
+
+	c.Get(ctx, "a")
+	v, err := c.GetMulti(ctx, []string{"a", "b", "c", "b"})
+	if err != nil || len(v) != 2 || string(v["a"]) != "va" || string(v["b"]) != "vb" {
+		t.Errorf("unexpected values %v %v", v, err)
+	}
+	if gets, bulks, _ := f.counts(); gets != 1 || bulks != 1 {
+		t.Error("expecting the misses to be fetched at once")
+	}
+	c.GetMulti(ctx, []string{"a", "b", "c"})
+	if gets, bulks, _ := f.counts(); gets != 1 || bulks != 1 {
+		t.Error("expecting the keys to be cached")
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeBackend()
+	c := New(f, 2, 10)
+
+	if err := c.Set(ctx, "a", []byte("va")); err != nil || string(f.data["a"]) != "va" {
+		t.Error("expecting the value to be stored in the backend")
+	}
+	if v, _ := c.Get(ctx, "a"); string(v) != "va" {
+		t.Error("expecting the value to be stored locally")
+	}
+	if gets, _, _ := f.counts(); gets != 0 {
+		t.Error("expecting no lookups")
+	}
+
+	f.err = errors.New("backend down")
+	if err := c.Set(ctx, "a", []byte("va2")); err != f.err {
+		t.Error("expecting backend error")
+	}
+	f.err = nil
+	if v, _ := c.Get(ctx, "a"); string(v) != "va" {
+		t.Error("expecting the failed write not to be cached")
+	}
+
+	r := New(struct{ Backend }{f}, 2, 10)
+	if err := r.Set(ctx, "a", []byte("va")); err != ErrReadOnly {
+		t.Error("expecting read only backend")
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeBackend()
+	c := New(f, 2, 10)
+	c.WriteMode = WriteBehind
+	c.WriteQueue = 10
+	var failed []string
+	c.OnWriteError = func(key string, err error) {
+		failed = append(failed, key)
+	}
+
+	for i := 0; i < 5; i++ {
+		c.Set(ctx, "a", []byte(strconv.Itoa(i)))
+	}
+	if v, _ := c.Get(ctx, "a"); string(v) != "4" {
+		t.Error("expecting the value to be stored locally right away")
+	}
+	c.Close()
+	if _, _, sets := f.counts(); sets != 5 || string(f.data["a"]) != "4" {
+		t.Error("expecting the writes to be done in order")
+	}
+	if len(failed) != 0 {
+		t.Error("expecting no errors")
+	}
+}
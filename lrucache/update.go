@@ -0,0 +1,260 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"time"
+)
+
+// Get the entry if it's present and not expired. An entry that has
+// expired doesn't count as present for the conditional updates, it's
+// as good as absent. Must be called with the lock held.
+func (b *TypedLRUCache[K, V]) liveEntry(key K, now time.Time) *entry[K, V] {
+	if e := b.table[key]; e != nil && !hasExpired(e.expire, now) {
+		return e
+	}
+	return nil
+}
+
+// SetIfAbsent adds an item to the cache only if the key is not there
+// or has expired. Returns true if the item was added.
+func (b *TypedLRUCache[K, V]) SetIfAbsent(key K, value V, expire time.Time) bool {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.setIfAbsentLocked(key, value, expire, &evs)
+}
+
+// Same as SetIfAbsent, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) setIfAbsentLocked(key K, value V, expire time.Time, evs *[]evicted[K, V]) bool {
+	now := b.now()
+	if b.liveEntry(key, now) != nil {
+		return false
+	}
+	b.set(key, value, expire, nil, now, evs)
+	return true
+}
+
+// Replace overwrites an item only if the key is in the cache and
+// hasn't expired. The item keeps its tags and idle timeout, expire is
+// the new deadline of an item with one. Returns true if the item was
+// replaced. With the Weigher set, a new value that doesn't fit evicts
+// the key and false is returned.
+func (b *TypedLRUCache[K, V]) Replace(key K, value V, expire time.Time) bool {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.replaceLocked(key, value, expire, &evs)
+}
+
+// Same as Replace, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) replaceLocked(key K, value V, expire time.Time, evs *[]evicted[K, V]) bool {
+	now := b.now()
+	e := b.liveEntry(key, now)
+	if e == nil {
+		return false
+	}
+	if !b.replaceValue(e, value, now, evs) {
+		return false
+	}
+	b.resetExpire(e, expire, now)
+	return true
+}
+
+// CompareAndSwap replaces the value of a key that hasn't expired if
+// it's equal to old, keeping the expiry time, tags and idle timeout.
+// Same as for sync.Map.CompareAndSwap, old must be of a comparable
+// type. Returns true if the value was swapped. With the Weigher set,
+// a new value that doesn't fit evicts the key and false is returned.
+func (b *TypedLRUCache[K, V]) CompareAndSwap(key K, old, new V) bool {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.compareAndSwapLocked(key, old, new, &evs)
+}
+
+// Same as CompareAndSwap, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) compareAndSwapLocked(key K, old, new V, evs *[]evicted[K, V]) bool {
+	now := b.now()
+	e := b.liveEntry(key, now)
+	if e == nil || any(e.value) != any(old) {
+		return false
+	}
+	return b.replaceValue(e, new, now, evs)
+}
+
+// Update calls fn with the current value of the key, ok is false if
+// the key is not there or has expired. The value returned by fn is
+// stored with the returned expiry time, like Replace does for a key
+// that is there, or the key is deleted if keep is false. Returns the
+// stored value. Fn is run with the lock held and must not use the
+// cache.
+func (b *TypedLRUCache[K, V]) Update(key K, fn func(old V, ok bool) (new V, expire time.Time, keep bool)) (value V, ok bool) {
+	var evs []evicted[K, V]
+	defer b.notifyEvicted(&evs)
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.updateLocked(key, fn, &evs)
+}
+
+// Same as Update, must be called with the lock held.
+func (b *TypedLRUCache[K, V]) updateLocked(key K, fn func(old V, ok bool) (new V, expire time.Time, keep bool), evs *[]evicted[K, V]) (value V, ok bool) {
+	now := b.now()
+	var old V
+	e := b.liveEntry(key, now)
+	if e != nil {
+		old = e.value
+	}
+	new, expire, keep := fn(old, e != nil)
+	if !keep {
+		if e := b.table[key]; e != nil {
+			b.evictEntry(e, EvictDeleted, evs)
+		}
+		return value, false
+	}
+	if e != nil {
+		if !b.replaceValue(e, new, now, evs) {
+			return value, false
+		}
+		b.resetExpire(e, expire, now)
+		return new, true
+	}
+	b.set(key, new, expire, nil, now, evs)
+	// Might not fit with the Weigher set.
+	if b.table[key] == nil {
+		return value, false
+	}
+	return new, true
+}
+
+func (m *TypedMultiLRUCache[K, V]) SetIfAbsent(key K, value V, expire time.Time) bool {
+	var evs []evicted[K, V]
+	c := m.lockShard(key)
+	defer c.notifyEvicted(&evs)
+	defer c.lock.Unlock()
+
+	return c.setIfAbsentLocked(key, value, expire, &evs)
+}
+
+func (m *TypedMultiLRUCache[K, V]) Replace(key K, value V, expire time.Time) bool {
+	var evs []evicted[K, V]
+	c := m.lockShard(key)
+	defer c.notifyEvicted(&evs)
+	defer c.lock.Unlock()
+
+	return c.replaceLocked(key, value, expire, &evs)
+}
+
+func (m *TypedMultiLRUCache[K, V]) CompareAndSwap(key K, old, new V) bool {
+	var evs []evicted[K, V]
+	c := m.lockShard(key)
+	defer c.notifyEvicted(&evs)
+	defer c.lock.Unlock()
+
+	return c.compareAndSwapLocked(key, old, new, &evs)
+}
+
+func (m *TypedMultiLRUCache[K, V]) Update(key K, fn func(old V, ok bool) (new V, expire time.Time, keep bool)) (value V, ok bool) {
+	var evs []evicted[K, V]
+	c := m.lockShard(key)
+	defer c.notifyEvicted(&evs)
+	defer c.lock.Unlock()
+
+	return c.updateLocked(key, fn, &evs)
+}
+
+// Get the entry if it's present and not expired, together with its
+// item. Must be called with the lock held.
+func (c *TypedClockCache[K, V]) liveEntry(key K, now time.Time) (*clockEntry[K, V], *clockItem[K, V]) {
+	if e, it := c.load(key); e != nil && !hasExpired(it.expire, now) {
+		return e, it
+	}
+	return nil, nil
+}
+
+// SetIfAbsent adds an item to the cache only if the key is not there
+// or has expired. Returns true if the item was added.
+func (c *TypedClockCache[K, V]) SetIfAbsent(key K, value V, expire time.Time) bool {
+	var evs []evicted[K, V]
+	defer c.notifyEvicted(&evs)
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	if e, _ := c.liveEntry(key, now); e != nil {
+		return false
+	}
+	c.set(key, value, expire, now, &evs)
+	return true
+}
+
+// Replace overwrites an item only if the key is in the cache and
+// hasn't expired. Returns true if the item was replaced.
+func (c *TypedClockCache[K, V]) Replace(key K, value V, expire time.Time) bool {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	e, _ := c.liveEntry(key, c.now())
+	if e == nil {
+		return false
+	}
+	c.store(e, key, value, expire)
+	e.touch()
+	return true
+}
+
+// CompareAndSwap replaces the value of a key that hasn't expired if
+// it's equal to old, keeping the expiry time. Old must be of a
+// comparable type.
+func (c *TypedClockCache[K, V]) CompareAndSwap(key K, old, new V) bool {
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	e, it := c.liveEntry(key, c.now())
+	if e == nil || any(it.value) != any(old) {
+		return false
+	}
+	c.store(e, key, new, it.expire)
+	e.touch()
+	return true
+}
+
+// Update calls fn with the current value of the key and stores the
+// result, see TypedLRUCache.Update.
+func (c *TypedClockCache[K, V]) Update(key K, fn func(old V, ok bool) (new V, expire time.Time, keep bool)) (value V, ok bool) {
+	var evs []evicted[K, V]
+	defer c.notifyEvicted(&evs)
+	c.acquireLock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	var old V
+	e, it := c.liveEntry(key, now)
+	if e != nil {
+		old = it.value
+	}
+	new, expire, keep := fn(old, e != nil)
+	if !keep {
+		if e, _ := c.load(key); e != nil {
+			c.evictEntry(e, EvictDeleted, &evs)
+		}
+		return value, false
+	}
+	if e != nil {
+		c.store(e, key, new, expire)
+		e.touch()
+		return new, true
+	}
+	c.set(key, new, expire, now, &evs)
+	if e, _ := c.load(key); e == nil {
+		return value, false
+	}
+	return new, true
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func testConditional(t *testing.T, name string, c TypedCache[string, int]) {
+	past := time.Now().Add(-time.Second)
+
+	if !c.SetIfAbsent("a", 1, time.Time{}) || c.SetIfAbsent("a", 2, time.Time{}) {
+		t.Errorf("%s: expecting A to be set only once", name)
+	}
+	if _, ok := c.GetNotStale("a"); !ok {
+		t.Errorf("%s: expecting A without expiry not to be stale", name)
+	}
+	c.Set("b", 1, past)
+	if !c.SetIfAbsent("b", 2, time.Time{}) {
+		t.Errorf("%s: expecting expired B to be replaced", name)
+	}
+
+	if c.Replace("c", 1, time.Time{}) {
+		t.Errorf("%s: expecting missing C not to be replaced", name)
+	}
+	if !c.Replace("a", 3, time.Time{}) {
+		t.Errorf("%s: expecting A to be replaced", name)
+	}
+	if v, _ := c.Get("a"); v != 3 {
+		t.Errorf("%s: expecting different value", name)
+	}
+
+	future := time.Now().Add(time.Hour)
+	c.Set("d", 1, future)
+	if c.CompareAndSwap("d", 2, 3) || !c.CompareAndSwap("d", 1, 3) {
+		t.Errorf("%s: expecting D to be swapped once", name)
+	}
+	ce := c.(interface {
+		GetWithExpire(key string) (int, time.Time, bool)
+	})
+	if v, expire, _ := ce.GetWithExpire("d"); v != 3 || !expire.Equal(future) {
+		t.Errorf("%s: expecting value swapped and expiry kept", name)
+	}
+
+	incr := func(old int, ok bool) (int, time.Time, bool) {
+		return old + 1, time.Time{}, true
+	}
+	c.Del("e")
+	if v, ok := c.Update("e", incr); !ok || v != 1 {
+		t.Errorf("%s: expecting E to be created", name)
+	}
+	if v, ok := c.Update("e", incr); !ok || v != 2 {
+		t.Errorf("%s: expecting E to be incremented", name)
+	}
+	c.Update("e", func(old int, ok bool) (int, time.Time, bool) {
+		return 0, time.Time{}, false
+	})
+	if _, ok := c.Get("e"); ok {
+		t.Errorf("%s: expecting E to be deleted", name)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c.Update("counter", incr)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := c.Get("counter"); v != 800 {
+		t.Errorf("%s: expecting updates not to be lost, got %d", name, v)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	t.Parallel()
+	testConditional(t, "LRUCache", NewTypedLRUCache[string, int](10))
+	testConditional(t, "MultiLRUCache", NewTypedMultiLRUCache[string, int](4, 10))
+	testConditional(t, "ClockCache", NewTypedClockCache[string, int](10))
+}
+
+func TestConditionalOnEvict(t *testing.T) {
+	t.Parallel()
+	evicted := map[EvictReason]int{}
+	b := &TypedLRUCache[string, int]{
+		OnEvict: func(key string, value int, reason EvictReason) {
+			evicted[reason] += 1
+		},
+	}
+	b.Init(10)
+
+	b.SetIfAbsent("a", 1, time.Time{})
+	b.CompareAndSwap("a", 1, 2)
+	b.Update("a", func(old int, ok bool) (int, time.Time, bool) {
+		return 0, time.Time{}, false
+	})
+	if evicted[EvictOverwritten] != 0 || evicted[EvictDeleted] != 1 {
+		t.Error("expecting different evictions")
+	}
+}
+
+func TestConditionalWeigher(t *testing.T) {
+	t.Parallel()
+	b := &TypedLRUCache[string, int]{
+		Weigher: func(key string, value int) uint64 {
+			return uint64(value)
+		},
+		MaxWeight: 10,
+	}
+	b.Init(10)
+
+	b.Set("a", 1, time.Time{})
+	if b.Replace("a", 20, time.Time{}) {
+		t.Error("expecting a value too heavy not to be replaced")
+	}
+	b.Set("b", 1, time.Time{})
+	if b.CompareAndSwap("b", 1, 20) {
+		t.Error("expecting a value too heavy not to be swapped")
+	}
+	if b.Len() != 0 {
+		t.Error("expecting the keys to be evicted")
+	}
+}
+
+func TestConditionalInPlace(t *testing.T) {
+	t.Parallel()
+	b := &TypedLRUCache[string, int]{Policy: PolicySLRU}
+	b.Init(4)
+	now := time.Now()
+	b.SetTagged("a", 1, time.Time{}, "t")
+	b.SetIdle("b", 1, time.Hour, now.Add(2*time.Hour))
+	b.Get("a")
+
+	b.Replace("a", 2, time.Time{})
+	b.CompareAndSwap("a", 2, 3)
+	b.Update("a", func(old int, ok bool) (int, time.Time, bool) {
+		return old + 1, time.Time{}, true
+	})
+	b.Replace("b", 2, now.Add(time.Minute))
+	b.Update("b", func(old int, ok bool) (int, time.Time, bool) {
+		return old + 1, now.Add(time.Minute), true
+	})
+
+	// A is protected after the lookup, new entries push out B.
+	for _, k := range []string{"c", "d", "e"} {
+		b.Set(k, 0, time.Time{})
+	}
+	if v, ok := b.GetQuiet("a"); !ok || v != 4 {
+		t.Error("expecting A to stay in the protected segment")
+	}
+	if b.InvalidateTag("t") != 1 {
+		t.Error("expecting the tags to be kept")
+	}
+
+	b.SetIdle("b", 1, time.Hour, now.Add(2*time.Hour))
+	b.Update("b", func(old int, ok bool) (int, time.Time, bool) {
+		return old + 1, now.Add(time.Minute), true
+	})
+	if e := b.table["b"]; e == nil || e.idle != time.Hour || !e.deadline.Equal(now.Add(time.Minute)) {
+		t.Error("expecting the idle timeout to be kept")
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2013 CloudFlare, Inc.
+
+package lrucache
+
+import (
+	"time"
+)
+
+// Evict entries until an item of a given weight fits, expired ones
+// first, then from the tail of the LRU. Returns false if the item
+// would never fit, or if that means evicting keep, the entry getting
+// the new weight, unless it's nil.
+func (b *TypedLRUCache[K, V]) makeRoom(weight uint64, keep *entry[K, V], now time.Time, evs *[]evicted[K, V]) bool {
+	if weight > b.MaxWeight {
+		return false
+	}
+	for b.weight+weight > b.MaxWeight {
+		if e := b.expiredEntry(now); e != nil && e != keep {
+			b.evictEntry(e, EvictExpired, evs)
+			continue
+		}
+		e := b.leastUsedEntry()
+		if e == keep {
+			return false
+		}
+		b.evictEntry(e, EvictCapacity, evs)
+	}
+	return true
+}
+
+// Weight gets the total weight of the entries in the LRU. Always zero
+// if the Weigher is not set.
+func (b *TypedLRUCache[K, V]) Weight() uint64 {
+	b.acquireLock()
+	defer b.lock.Unlock()
+
+	return b.weight
+}
+
+// Set the weigher for each cache in the multicache instance. The
+// maximum weight is per shard, the same as the capacity passed to
+// Init. Must be called before the cache is used.
+func (m *TypedMultiLRUCache[K, V]) SetWeigher(weigher func(key K, value V) uint64, bucketMaxWeight uint64) {
+	for _, c := range m.shards.Load().cache {
+		c.Weigher = weigher
+		c.MaxWeight = bucketMaxWeight
+	}
+}
+
+// Weight gets the total weight of the entries in all the shards.
+func (m *TypedMultiLRUCache[K, V]) Weight() uint64 {
+	var s uint64
+	m.eachShard(func() { s = 0 }, func(c *TypedLRUCache[K, V], evs *[]evicted[K, V]) {
+		s += c.weight
+	})
+	return s
+}